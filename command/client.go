@@ -0,0 +1,163 @@
+package command
+
+import (
+	"net"
+	protocol "redisgo/protocol"
+	storage "redisgo/storage"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClientFlag describes the state of a client connection
+type ClientFlag uint
+
+const (
+	// ClientPreventPropagation stops the propagation of the command being
+	// executed, e.g. a write command that didn't change anything
+	ClientPreventPropagation ClientFlag = 1 << iota
+	// ClientMulti is set between MULTI and EXEC, the commands are queued
+	ClientMulti
+	// ClientDirtyExec is set when a command couldn't be queued, EXEC fails
+	ClientDirtyExec
+	// ClientDenyBlocking makes blocking commands return immediately, it is
+	// set while the commands of a transaction are executed
+	ClientDenyBlocking
+)
+
+var lastClientID atomic.Int64
+
+// Client holds the state of a client connection. It is created when the
+// connection is accepted and passed to every command executed by the client.
+// The protocol version negotiated with HELLO is kept by the reply writer.
+type Client struct {
+	ID              int64
+	Addr            string
+	Created         time.Time
+	LastCommand     string
+	LastInteraction time.Time
+	Flags           ClientFlag
+	Name            string
+
+	// Cmd is the command being executed
+	Cmd *Command
+
+	// commands propagated in place of Cmd, see Propagate
+	propagated [][]string
+
+	// set while a command runs through the Propagate middleware, the
+	// commands it executes add their effects to its propagated commands
+	propagating bool
+
+	// set by PropagateTransaction
+	transaction bool
+
+	// the client a nested client executes commands for, see Nested
+	parent *Client
+
+	// commands queued after MULTI
+	queue []queuedCommand
+
+	// keys watched with WATCH, nil if there aren't any
+	watch *storage.Watch
+
+	// Writer buffers the replies sent to the client
+	Writer *protocol.Writer
+
+	// Gate is the lock held while the command being executed runs, Block
+	// releases it while a blocking command waits. It is nil if the command
+	// doesn't run under a gate.
+	Gate sync.Locker
+}
+
+// NewClient returns a client with a unique id that writes its replies to conn
+func NewClient(conn net.Conn) *Client {
+	now := time.Now()
+	return &Client{
+		ID:              lastClientID.Add(1),
+		Addr:            conn.RemoteAddr().String(),
+		Created:         now,
+		LastInteraction: now,
+		Writer:          protocol.NewWriter(conn),
+	}
+}
+
+// Protocol returns the RESP version used by the client
+func (c *Client) Protocol() int {
+	return c.Writer.Protocol
+}
+
+func (c *Client) HasFlag(f ClientFlag) bool {
+	return c.Flags&f != 0
+}
+
+// Nested returns a client that executes commands on behalf of c, like the
+// commands called by a script. It has the flags and the state of c, so the
+// middlewares see the same client, but its blocking commands return
+// immediately and its replies are written to w. The effects of its commands
+// are propagated with the command c is executing.
+func (c *Client) Nested(w *protocol.Writer) *Client {
+	nested := *c
+	nested.Writer = w
+	nested.Flags |= ClientDenyBlocking
+	nested.Gate = nil
+	nested.propagated = nil
+	nested.propagating = false
+	nested.transaction = false
+	nested.parent = c
+	nested.queue = nil
+	nested.watch = nil
+	return &nested
+}
+
+// Block calls wait without holding Gate, blocking commands wait for data in
+// wait so transactions and scripts can run meanwhile. The gate is taken again
+// before Block returns, wait must not access the storage.
+func (c *Client) Block(wait func()) {
+	if c.Gate != nil {
+		c.Gate.Unlock()
+		defer c.Gate.Lock()
+	}
+	wait()
+}
+
+// Propagate sends args to the replicas and the persistence log in place of
+// the command being executed, it can be called more than once to propagate
+// several commands. Commands with effects that depend on the current data,
+// the time or random values propagate their effects instead of themselves.
+func (c *Client) Propagate(args ...string) {
+	c.propagated = append(c.propagated, args)
+}
+
+// PropagateTransaction propagates the effects of the commands executed by the
+// command being executed inside MULTI/EXEC, so the replicas apply them
+// atomically. They are propagated even if the command fails after them, like
+// a script that raises an error after a write. Inside a transaction they are
+// part of the outer MULTI/EXEC.
+func (c *Client) PropagateTransaction() {
+	c.transaction = true
+}
+
+type queuedCommand struct {
+	cmd  *Command
+	args []string
+}
+
+// Queue adds a command to the transaction started with MULTI
+func (c *Client) Queue(cmd *Command, args []string) {
+	c.queue = append(c.queue, queuedCommand{cmd: cmd, args: args})
+}
+
+// discardTransaction drops the queued commands and leaves the MULTI state
+func (c *Client) discardTransaction() {
+	c.queue = nil
+	c.Flags &^= ClientMulti | ClientDirtyExec
+}
+
+// Unwatch stops watching the keys added with WATCH, it must be called when
+// the connection is closed
+func (c *Client) Unwatch() {
+	if c.watch != nil {
+		c.watch.Clear()
+	}
+}
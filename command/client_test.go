@@ -0,0 +1,97 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"redisgo/protocol"
+	"sync"
+	"testing"
+)
+
+func TestNewClientIds(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+	defer conn1.Close()
+	defer conn2.Close()
+
+	c1 := NewClient(conn1)
+	c2 := NewClient(conn2)
+	if c1.ID == c2.ID || c2.ID <= c1.ID {
+		t.Errorf("expected increasing client ids, got %d and %d", c1.ID, c2.ID)
+	}
+	if c1.Protocol() != protocol.RESP2 {
+		t.Errorf("expected RESP2 by default, got %d", c1.Protocol())
+	}
+}
+
+func TestHelloUpdatesClient(t *testing.T) {
+	var buf bytes.Buffer
+	client := &Client{ID: 42, Writer: protocol.NewWriter(&buf)}
+	ctx := context.Background()
+
+	err := (&Hello{Role: "master"}).Execute([]string{"3", "SETNAME", "app"}, &ctx, client)
+	client.Writer.Flush()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.Name != "app" || client.Protocol() != protocol.RESP3 {
+		t.Errorf("expected name app and RESP3, got %q and %d", client.Name, client.Protocol())
+	}
+
+	values, err := (&protocol.RedisProtocolParser{}).Decode(buf.Bytes())
+	if err != nil || len(values) != 1 {
+		t.Fatalf("invalid HELLO reply %q: %v", buf.String(), err)
+	}
+	reply := values[0].Array
+	found := false
+	for i := 0; i+1 < len(reply); i += 2 {
+		if reply[i].Str == "id" {
+			found = reply[i+1].Int == 42
+		}
+	}
+	if !found {
+		t.Errorf("expected id 42 in HELLO reply %q", buf.String())
+	}
+}
+
+func TestBlockReleasesTheGate(t *testing.T) {
+	var gate sync.RWMutex
+	client := &Client{Gate: gate.RLocker()}
+
+	gate.RLock()
+	client.Block(func() {
+		// a transaction can take the gate while the client waits
+		if !gate.TryLock() {
+			t.Errorf("expected the gate to be released while waiting")
+			return
+		}
+		gate.Unlock()
+	})
+	if gate.TryLock() {
+		t.Errorf("expected the gate to be taken again after Block")
+	}
+	gate.RUnlock()
+}
+
+func TestNestedClient(t *testing.T) {
+	var buf bytes.Buffer
+	authenticated := ClientFlag(1 << 20)
+	client := &Client{ID: 7, Name: "app", Addr: "10.0.0.1:1234", Flags: authenticated, Writer: protocol.NewWriter(io.Discard)}
+
+	nested := client.Nested(protocol.NewWriter(&buf))
+	if nested.ID != 7 || nested.Name != "app" || nested.Addr != client.Addr {
+		t.Errorf("expected the nested client to keep the state of the caller")
+	}
+	if !nested.HasFlag(authenticated) || !nested.HasFlag(ClientDenyBlocking) {
+		t.Errorf("expected the flags of the caller and ClientDenyBlocking, got %b", nested.Flags)
+	}
+	if client.HasFlag(ClientDenyBlocking) {
+		t.Errorf("expected the caller to be unchanged")
+	}
+	nested.Writer.WriteOK()
+	nested.Writer.Flush()
+	if buf.String() != "+OK\r\n" {
+		t.Errorf("expected the nested client to write to its own writer, got %q", buf.String())
+	}
+}
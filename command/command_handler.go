@@ -2,9 +2,21 @@ package command
 
 import (
 	"context"
-	"net"
 )
 
 type CommandHandler interface {
-	Execute(args []string, ctx *context.Context, conn net.Conn) error
+	Execute(args []string, ctx *context.Context, client *Client) error
 }
+
+// HandlerFunc allows the use of ordinary functions as command handlers
+type HandlerFunc func(args []string, ctx *context.Context, client *Client) error
+
+func (f HandlerFunc) Execute(args []string, ctx *context.Context, client *Client) error {
+	return f(args, ctx, client)
+}
+
+// Middleware wraps the execution of a command to add behaviour shared by all
+// the commands, like auditing or rate limiting. The command being executed is
+// available in client.Cmd, the error returned by next is the result of the
+// command: nil, a CommandError sent to the client or an I/O error.
+type Middleware func(next CommandHandler) CommandHandler
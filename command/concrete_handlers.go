@@ -4,10 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net"
+	"math"
 	protocol "redisgo/protocol"
 	storage "redisgo/storage"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,39 +17,128 @@ import (
 // PING
 type PingHandler struct{}
 
-func (p *PingHandler) Execute(args []string, ctx *context.Context, conn net.Conn) error {
-	_, err := conn.Write([]byte("+PONG\r\n"))
-	return err
+func (p *PingHandler) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
+	return w.WriteSimpleString("PONG")
 }
 
 // ECHO
-type EchoHandler struct {
-	Parser protocol.Parser
-}
+type EchoHandler struct{}
 
-func (e *EchoHandler) Execute(args []string, ctx *context.Context, conn net.Conn) error {
+func (e *EchoHandler) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
 	if len(args) == 0 {
-		return errors.New("ECHO command requires an argument")
+		return ErrWrongNumberOfArgs(protocol.ECHO)
 	}
-	_, err := conn.Write([]byte(e.Parser.EncodeBulkString(args[0], true)))
-	return err
+	return w.WriteBulk(args[0])
+}
+
+// HELLO
+type Hello struct {
+	Role string
+}
+
+func (h *Hello) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
+	version := w.Protocol
+	name := client.Name
+
+	if len(args) > 0 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil {
+			return NewError("Protocol version is not an integer or out of range")
+		}
+		if v != protocol.RESP2 && v != protocol.RESP3 {
+			return &CommandError{Msg: "NOPROTO unsupported protocol version"}
+		}
+		version = v
+
+		for i := 1; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case protocol.AUTH:
+				if i+2 >= len(args) {
+					return NewError("Syntax error in HELLO option 'auth'")
+				}
+				// there is no ACL support, the default user doesn't require a password
+				if args[i+1] != "default" {
+					return &CommandError{Msg: "WRONGPASS invalid username-password pair or user is disabled."}
+				}
+				i += 2
+			case protocol.SETNAME:
+				if i+1 >= len(args) {
+					return NewError("Syntax error in HELLO option 'setname'")
+				}
+				if strings.ContainsAny(args[i+1], " \r\n") {
+					return NewError("Client names cannot contain spaces, newlines or special characters.")
+				}
+				name = args[i+1]
+				i++
+			default:
+				return NewError("Syntax error in HELLO option '%s'", args[i])
+			}
+		}
+	}
+
+	w.Protocol = version
+	client.Name = name
+
+	w.WriteMapHeader(7)
+	w.WriteBulk("server")
+	w.WriteBulk("redis")
+	w.WriteBulk("version")
+	w.WriteBulk("7.2.0")
+	w.WriteBulk("proto")
+	w.WriteInt(int64(version))
+	w.WriteBulk("id")
+	w.WriteInt(client.ID)
+	w.WriteBulk("mode")
+	w.WriteBulk("standalone")
+	w.WriteBulk("role")
+	w.WriteBulk(h.Role)
+	w.WriteBulk("modules")
+	return w.WriteArrayHeader(0)
+}
+
+// INFO
+type InfoProvider interface {
+	GetFormattedInfo() string
+}
+
+type Info struct {
+	Provider InfoProvider
+}
+
+func (i *Info) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
+	content := ""
+	section := "default"
+	if len(args) > 0 {
+		section = strings.ToLower(args[0])
+	}
+	switch section {
+	case "replication", "default", "all", "everything":
+		content = "# Replication\r\n" + i.Provider.GetFormattedInfo()
+	}
+
+	// RESP3 clients receive the info as a verbatim string
+	return w.WriteVerbatim("txt", content)
 }
 
 // GET
 type GetHandler struct {
 	Storage *storage.Storage
-	Parser  protocol.Parser
 }
 
-func (g *GetHandler) Execute(args []string, ctx *context.Context, conn net.Conn) error {
-	value, ok := g.Storage.Get(args[0])
-	if !ok {
-		_, err := conn.Write(nilResponse()) // Return null bulk string for non-existing key
+func (g *GetHandler) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
+	value, ok, err := g.Storage.Get(args[0])
+	if err != nil {
 		return err
 	}
-	encondedResp := g.Parser.EncodeBulkString(value, true)
-	_, err := conn.Write([]byte(encondedResp))
-	return err
+	if !ok {
+		return w.WriteNull() // Return null bulk string for non-existing key
+	}
+	return w.WriteBulk(value)
 }
 
 // SET
@@ -57,55 +147,75 @@ type SetHandler struct {
 	ReplicaChan chan []byte
 }
 
-func (s *SetHandler) Execute(args []string, ctx *context.Context, conn net.Conn) error {
+func (s *SetHandler) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
 	if len(args[0]) == 0 {
-		_, err := conn.Write([]byte("-ERR invalid key value\r\n"))
-		return err
+		return NewError("invalid key value")
 	}
 	key := args[0]
 	value := args[1]
-	s.Storage.Set(key, value)
-	if len(args) == 4 {
-		var expTime time.Duration
-		if strings.ToLower(args[2]) == protocol.PX {
-			t, err := strconv.Atoi(args[3])
-			if err != nil {
-				return errors.New("invalid expire time")
-			}
-			expTime = time.Duration(t) * time.Millisecond
-		}
 
-		if strings.ToLower(args[2]) == protocol.EX {
-			t, err := strconv.Atoi(args[3])
-			if err != nil {
-				return errors.New("invalid expire time")
-			}
-			expTime = time.Duration(t) * time.Second
+	// the expire time is validated before the value is stored
+	var expireAt time.Time
+	for i := 2; i < len(args); i += 2 {
+		option := strings.ToLower(args[i])
+		if i+1 == len(args) || !expireAt.IsZero() {
+			return ErrSyntax
+		}
+		t, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return ErrNotInteger
+		}
+		var at int64
+		var ok bool
+		switch option {
+		case protocol.EX:
+			at, ok = unixMilli(t, time.Second, false)
+		case protocol.PX:
+			at, ok = unixMilli(t, time.Millisecond, false)
+		case protocol.EXAT:
+			at, ok = unixMilli(t, time.Second, true)
+		case protocol.PXAT:
+			at, ok = unixMilli(t, time.Millisecond, true)
+		default:
+			return ErrSyntax
+		}
+		if t <= 0 || !ok {
+			return NewError("invalid expire time in '%s' command", protocol.SET)
 		}
+		expireAt = time.UnixMilli(at)
+	}
 
-		go func() {
-			time.Sleep(expTime)
-			s.Storage.DeleteValue(key)
-		}()
+	s.Storage.Do(func(tx *storage.Tx) error {
+		tx.Set(key, value)
+		if !expireAt.IsZero() {
+			tx.SetExpire(key, expireAt)
+		}
+		return nil
+	})
+	if !expireAt.IsZero() {
+		// relative times are propagated as an absolute time, so replicas
+		// expire the key at the same time as the master
+		client.Propagate(protocol.SET, key, value, protocol.PXAT, strconv.FormatInt(expireAt.UnixMilli(), 10))
 	}
 
-	_, err := conn.Write(okResponse())
-	return err
+	return w.WriteOK()
 }
 
 // LRANGE
 type LRange struct {
 	Storage *storage.Storage
-	Parser  protocol.Parser
 }
 
-func (l *LRange) Execute(args []string, ctx *context.Context, conn net.Conn) error {
+func (l *LRange) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
 	start, _ := strconv.Atoi(args[1])
 	stop, _ := strconv.Atoi(args[2])
-	values := l.Storage.GetSliceFromList(args[0], start, stop)
-	encoded := l.Parser.EncodeAsArray(values)
-	_, err := conn.Write([]byte(encoded))
-	return err
+	values, err := l.Storage.GetSliceFromList(args[0], start, stop)
+	if err != nil {
+		return err
+	}
+	return w.WriteBulkArray(values)
 }
 
 // LPUSH
@@ -113,27 +223,28 @@ type LPush struct {
 	Storage *storage.Storage
 }
 
-func (l *LPush) Execute(args []string, ctx *context.Context, conn net.Conn) error {
+func (l *LPush) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
 	if len(args[0]) == 0 {
-		conn.Write([]byte("-ERR invalid key value\r\n"))
-		return fmt.Errorf("empty key value")
+		return NewError("invalid key value")
 	}
 
 	key := args[0]
-	values := args[1:]
 
-	// invert values
-	for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
-		values[i], values[j] = values[j], values[i]
+	// invert values, args are not modified because they can be propagated
+	values := make([]string, len(args)-1)
+	for i, v := range args[1:] {
+		values[len(values)-1-i] = v
 	}
 	
-	n := l.Storage.PrependValuesToList(key, values...)
+	n, err := l.Storage.PrependValuesToList(key, values...)
+	if err != nil {
+		return err
+	}
 	if len(values) > 0{
 		l.Storage.NotifyWaiter(key, values[0])
 	}
-	resp := fmt.Sprintf(":%d\r\n", n)
-	_, err := conn.Write([]byte(resp))
-	return err
+	return w.WriteInt(int64(n))
 }
 
 // RPUSH
@@ -141,22 +252,23 @@ type RPush struct {
 	Storage *storage.Storage
 }
 
-func (s *RPush) Execute(args []string, ctx *context.Context, conn net.Conn) error {
+func (s *RPush) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
 	if len(args[0]) == 0 {
-		conn.Write([]byte("-ERR invalid key value\r\n"))
-		return fmt.Errorf("empty key value")
+		return NewError("invalid key value")
 	}
 
 	key := args[0]
 	values := args[1:]
-	n := s.Storage.AppendValuesToList(key, values...)
+	n, err := s.Storage.AppendValuesToList(key, values...)
+	if err != nil {
+		return err
+	}
 
 	if n > 0  && n == len(values){
 		s.Storage.NotifyWaiter(key, values[0])
 	}
-	resp := fmt.Sprintf(":%d\r\n", n)
-	_, err := conn.Write([]byte(resp))
-	return err
+	return w.WriteInt(int64(n))
 }
 
 // LLEN
@@ -165,103 +277,160 @@ type LLEN struct {
 	Storage *storage.Storage
 }
 
-func (l *LLEN) Execute(args []string, ctx *context.Context, conn net.Conn) error {
-	n := l.Storage.GetListLenght(args[0])
-	resp := fmt.Sprintf(":%d\r\n", n)
-	_, err := conn.Write([]byte(resp))
-	return err
+func (l *LLEN) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
+	n, err := l.Storage.GetListLenght(args[0])
+	if err != nil {
+		return err
+	}
+	return w.WriteInt(int64(n))
 
 }
 
 // LPOP
 type LPOP struct {
 	Storage *storage.Storage
-	Parser  protocol.Parser
 }
 
-func (l *LPOP) Execute(args []string, ctx *context.Context, conn net.Conn) error {
-	resp := []byte{}
+func (l *LPOP) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
 	if len(args) == 2 {
 		n,_ := strconv.Atoi(args[1])
-		values := l.Storage.RemoveFirstElementsFromTheList(args[0], n-1)
-		resp = []byte(l.Parser.EncodeAsArray(values))
-	} else {
-		value := l.Storage.RemoveElementFromListByIndex(args[0], 0)
-		resp = []byte(l.Parser.EncodeBulkString(value, true))
+		values, err := l.Storage.RemoveFirstElementsFromTheList(args[0], n-1)
+		if err != nil {
+			return err
+		}
+		return w.WriteBulkArray(values)
+	}
+	value, err := l.Storage.RemoveElementFromListByIndex(args[0], 0)
+	if err != nil {
+		return err
 	}
-	_, err := conn.Write([]byte(resp))
-	return err
+	return w.WriteBulk(value)
 }
 
 // BLPOP
 
+// BLPOP pops the first element of the first non empty list among the keys,
+// the last argument is the timeout in seconds
 type BLPOP struct {
 	Storage *storage.Storage
-	Parser protocol.Parser
 }
 
-func (b *BLPOP) Execute(args []string, ctx *context.Context, conn net.Conn) error {
-	key := args[0]
-	value := b.Storage.RemoveElementFromListByIndex(key,0)
-	if value != ""{
-		t := []string{key, value}
-		resp := []byte(b.Parser.EncodeAsArray(t))
-		_, err := conn.Write([]byte(resp))
+func (b *BLPOP) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
+	keys := args[:len(args)-1]
+	secs, err := strconv.ParseFloat(args[len(args)-1], 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return NewError("timeout is not a float or out of range")
+	}
+	if secs < 0 {
+		return NewError("timeout is negative")
+	}
+
+	key, value, err := b.pop(keys)
+	if err != nil {
 		return err
 	}
+	if value != ""{
+		client.Propagate(protocol.LPOP, key)
+		return w.WriteBulkArray([]string{key, value})
+	}
 
-	timeout := time.Hour
+	// BLPOP is propagated as the LPOP that removed the value, a replica
+	// must never block. Nothing is propagated if no value was removed.
+	client.Flags |= ClientPreventPropagation
 
-	if len(args) == 2{
-		secs,_ := strconv.ParseFloat(args[1], 32)		
-		if secs > 0 {
-			milisecs := int(secs * 1000)
-			timeout = time.Duration(milisecs) * time.Millisecond 
-		}
+	// inside a transaction the command can't wait for a value
+	if client.HasFlag(ClientDenyBlocking) {
+		return w.WriteNull()
+	}
+
+	// a zero timeout blocks forever, receiving from a nil channel never
+	// succeeds
+	var timeout <-chan time.Time
+	if secs > 0 {
+		milisecs := int64(secs * 1000)
+		timeout = time.After(time.Duration(milisecs) * time.Millisecond)
 	}
 
 	waitChan := make(chan string, 1)
-	b.Storage.RegisterWaiter(key, waitChan)
-	defer func() {
-		b.Storage.UnregisterWaiter(key, waitChan)
-	}()
-
-	select{
-	case <- waitChan:
-		val := b.Storage.RemoveElementFromListByIndex(key, 0) 
-		if val != ""{
-			t := []string{key, val}
-			resp := []byte(b.Parser.EncodeAsArray(t))
-			_, err := conn.Write([]byte(resp))
+	register := func() {
+		for _, key := range keys {
+			b.Storage.RegisterWaiter(key, waitChan)
+		}
+	}
+	unregister := func() {
+		for _, key := range keys {
+			b.Storage.UnregisterWaiter(key, waitChan)
+		}
+	}
+	register()
+	defer unregister()
+
+	for {
+		woken, timedOut := false, false
+		client.Block(func() {
+			select{
+			case <- waitChan:
+				woken = true
+			case <- timeout:
+				timedOut = true
+			case <-(*ctx).Done():
+			}
+		})
+		switch {
+		case timedOut:
+			return w.WriteNull()
+		case !woken:
+			// the server is shutting down, the connection is closed
+			return (*ctx).Err()
+		}
+
+		// another client can pop the value first, then we wait again, a
+		// notified waiter is removed from the waiters of the key
+		key, value, err := b.pop(keys)
+		if err != nil {
 			return err
 		}
-		return nil
-	case <- time.After(timeout):
-		_, err := conn.Write(nilResponse())
-		return err
+		if value != ""{
+			client.Flags &^= ClientPreventPropagation
+			client.Propagate(protocol.LPOP, key)
+			return w.WriteBulkArray([]string{key, value})
+		}
+		unregister()
+		register()
+	}
+}
+
+// pop removes the first element of the first non empty list among keys
+func (b *BLPOP) pop(keys []string) (key, value string, err error) {
+	for _, key := range keys {
+		value, err := b.Storage.RemoveElementFromListByIndex(key, 0)
+		if err != nil || value != "" {
+			return key, value, err
+		}
 	}
+	return "", "", nil
 }
 
 // TYPE
 
 type Type struct {
 	Storage *storage.Storage
-	Parser  protocol.Parser
 }
 
 
-func (t *Type) Execute(args []string, ctx *context.Context, conn net.Conn) error {
+func (t *Type) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
 	valueType := t.Storage.CheckType(args[0])
-	resp := []byte(t.Parser.EncodeAsSimpleString(valueType, true))
-	_, err := conn.Write(resp)
-	return err
+	return w.WriteSimpleString(valueType)
 }
 
 // XADD
 
 type XAdd struct {
 	Storage *storage.Storage
-	Parser protocol.Parser
 }
 
 const (
@@ -272,11 +441,15 @@ const (
 	INVALID_ID
 )
 
-func (x *XAdd) Execute(args []string, ctx *context.Context, conn net.Conn) error {
+func (x *XAdd) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
 	key := args[0]
 	newEntryId := args[1]
 
-	lastEntry,listLen := x.Storage.GetLastEntryStream(key)
+	lastEntry, listLen, err := x.Storage.GetLastEntryStream(key)
+	if err != nil {
+		return err
+	}
 	lastEntryId := "0-0"
 	if lastEntry != nil {
 		lastEntryId = lastEntry["id"]
@@ -292,9 +465,7 @@ func (x *XAdd) Execute(args []string, ctx *context.Context, conn net.Conn) error
 		lastTimestamp, lastIndex := parseStreamEntryId(lastEntryId)
 
 		if !(newTimestamp >= lastTimestamp){
-			resp := x.Parser.EncodeError("The ID specified in XADD is equal or smaller than the target stream top item")
-			_, err := conn.Write([]byte(resp))
-			return err
+			return NewError("The ID specified in XADD is equal or smaller than the target stream top item")
 		}
 		if lastTimestamp == newTimestamp {
 			lastIndex++
@@ -305,18 +476,12 @@ func (x *XAdd) Execute(args []string, ctx *context.Context, conn net.Conn) error
 
 	case EXPLICIT_ID:
 		if err := checkEntryStreamId(newEntryId, lastEntryId, listLen); err != nil {
-			resp := x.Parser.EncodeError(err.Error())
-			_, err := conn.Write([]byte(resp))
-			return err
+			return NewError("%s", err.Error())
 		}
 	case ZEROS_ID:
-		resp := x.Parser.EncodeError("The ID specified in XADD must be greater than 0-0")
-		_, err := conn.Write([]byte(resp))
-		return err
+		return NewError("The ID specified in XADD must be greater than 0-0")
 	case INVALID_ID:
-		resp := x.Parser.EncodeError("Invalid id format")
-		_, err := conn.Write([]byte(resp))
-		return err
+		return NewError("Invalid id format")
 	}
 
 
@@ -324,9 +489,7 @@ func (x *XAdd) Execute(args []string, ctx *context.Context, conn net.Conn) error
 	keyValues := args[2:]
 
 	if len(keyValues) % 2 != 0 {
-		resp := x.Parser.EncodeError("Invalid number of arguments")
-		_, err := conn.Write([]byte(resp))
-		return err
+		return NewError("Invalid number of arguments")
 	}
 
 	data := map[string]string{
@@ -337,14 +500,10 @@ func (x *XAdd) Execute(args []string, ctx *context.Context, conn net.Conn) error
 	}
 
 	if err := x.Storage.AddEntryStream(key,data); err != nil {
-		resp := x.Parser.EncodeError("server error")
-		_, err := conn.Write([]byte(resp))
 		return err
 	}
 
-	resp := x.Parser.EncodeBulkString(newEntryId, true)
-	_, err := conn.Write([]byte(resp))
-	return err
+	return w.WriteBulk(newEntryId)
 }
 
 func parseStreamEntryId(id string) (int64, int){
@@ -410,41 +569,56 @@ func checkEntryStreamId(newId, lastId string, listLen int) error{
 // XRANGE
 type XRange struct{
 	Storage *storage.Storage
-	Parser protocol.Parser
 }
 
-func (x *XRange) Execute(args []string, ctx *context.Context, conn net.Conn) error {
+func (x *XRange) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
 	key := args[0]
 	startStr := args[1]
 	endStr := args[2]
 
 	startTimestamp, startIndex, err := parseStreamRangeId(startStr)
 	if err != nil {
-		_, err := conn.Write([]byte(err.Error()))
-		return err
+		return NewError("%s", err.Error())
 	}
 
 	endTimestamp, endIndex, err := parseStreamRangeId(endStr)
 	if err != nil {
-		_, err := conn.Write([]byte(err.Error()))
-		return err
+		return NewError("%s", err.Error())
 	}
 
-	data := x.Storage.GetStreamEntriesByRange(key, startTimestamp, endTimestamp, startIndex, endIndex)
-	if len(data)==0{
-		_, err = conn.Write(nilResponse())
+	data, err := x.Storage.GetStreamEntriesByRange(key, startTimestamp, endTimestamp, startIndex, endIndex)
+	if err != nil {
 		return err
 	}
-	contentResp := make([]string,0, len(data)) 
-	for _,m:= range data {
-		keyBulkString := x.Parser.EncodeBulkString(m["id"], true)
-		delete(m,"id")
-		mapContent := x.Parser.MapToArray(m)
-		contentResp = append(contentResp, x.Parser.ConcatenateArray([]string{keyBulkString, mapContent}))
+	if len(data)==0{
+		return w.WriteNull()
+	}
+	return writeStreamEntries(w, data)
+}
+
+// writeStreamEntries writes the entries as an array of [id, fields] pairs,
+// the fields are sent as a map to RESP3 clients and as a flat array to RESP2 clients
+func writeStreamEntries(w *protocol.Writer, entries []map[string]string) error {
+	w.WriteArrayHeader(len(entries))
+	for _, m := range entries {
+		id := m["id"]
+		delete(m, "id")
+		fields := make([]string, 0, len(m))
+		for k := range m {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+
+		w.WriteArrayHeader(2)
+		w.WriteBulk(id)
+		w.WriteMapHeader(len(fields))
+		for _, k := range fields {
+			w.WriteBulk(k)
+			w.WriteBulk(m[k])
+		}
 	}
-	 resp := x.Parser.ConcatenateArray(contentResp)
-	 _, err = conn.Write([]byte(resp))
-	return err
+	return nil
 }
 
 func parseStreamRangeId(id string) (int64, int, error) {
@@ -471,58 +645,61 @@ func parseStreamRangeId(id string) (int64, int, error) {
 
 type XRead struct{
 	Storage *storage.Storage
-	Parser  protocol.Parser
 }
 
-func (x *XRead) Execute(args []string, ctx *context.Context, conn net.Conn) error {
+func (x *XRead) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
 	keysIds := args[1:]
 	if len(keysIds) % 2 != 0 {
-		resp := x.Parser.EncodeError("Invalid number of arguments")
-		_, err := conn.Write(resp)
-		return err
+		return NewError("Invalid number of arguments")
 	}
 
 	keys := keysIds[:len(keysIds) / 2]
 	ids  := keysIds[len(keysIds) / 2:]
 
-	respData := make([]string, len(keys))
+	streams := make([][]map[string]string, len(keys))
 	for i := range len(keys){
 		key := keys[i]
 		idStr := ids[i]
 		timestamp, index := parseStreamEntryId(idStr)
 
-		data := x.Storage.GetStreamEntriesByPartialRange(key, timestamp, index)
-		if len(data) == 0 {
-			_, err := conn.Write(nilResponse())
+		data, err := x.Storage.GetStreamEntriesByPartialRange(key, timestamp, index)
+		if err != nil {
 			return err
 		}
-
-
-		encodedStreamsData := make([]string,0, len(data)) 
-		for _,m:= range data {
-			keyBulkString := x.Parser.EncodeBulkString(m["id"], true)
-			delete(m,"id")
-			mapContent := x.Parser.MapToArray(m)
-			encodedStreamsData = append(encodedStreamsData, x.Parser.ConcatenateArray([]string{keyBulkString, mapContent}))
+		if len(data) == 0 {
+			return w.WriteNull()
 		}
-
-		streamDataWithKey:= []string{x.Parser.EncodeBulkString(key, true), x.Parser.ConcatenateArray(encodedStreamsData)}
-
-		respData[i] = x.Parser.ConcatenateArray(streamDataWithKey)
+		streams[i] = data
 	}
 
-	s := x.Parser.ConcatenateArray(respData)	
-	_, err := conn.Write([]byte(s))
-	return err
-}
-
-type PSync struct {
+	// RESP3 clients receive a map of stream name -> entries
+	if w.Protocol == protocol.RESP3 {
+		w.WriteMapHeader(len(keys))
+	} else {
+		w.WriteArrayHeader(len(keys))
+	}
+	for i, key := range keys {
+		if w.Protocol != protocol.RESP3 {
+			w.WriteArrayHeader(2)
+		}
+		w.WriteBulk(key)
+		writeStreamEntries(w, streams[i])
+	}
+	return nil
 }
 
-func okResponse() []byte {
-	return []byte("+OK\r\n")
+// XReadKeys returns the streams of an XREAD command, they are the first half
+// of the arguments that follow the STREAMS option
+func XReadKeys(args []string) []string {
+	for i, arg := range args {
+		if strings.ToLower(arg) == "streams" {
+			rest := args[i+1:]
+			return rest[:len(rest)/2]
+		}
+	}
+	return nil
 }
 
-func nilResponse() []byte {
-	return []byte("$-1\r\n")
+type PSync struct {
 }
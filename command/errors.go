@@ -0,0 +1,48 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	storage "redisgo/storage"
+)
+
+// CommandError is a failure of a single command, like a wrong number of
+// arguments or a value with an invalid format. It is sent to the client as an
+// error reply and the connection stays open. Msg starts with the error code,
+// e.g. "ERR syntax error" or "WRONGPASS invalid username-password pair".
+//
+// Any other error returned by a handler is treated as an I/O error and the
+// connection is closed.
+type CommandError struct {
+	Msg string
+}
+
+func (e *CommandError) Error() string {
+	return e.Msg
+}
+
+// NewError returns a command error with the generic "ERR" code
+func NewError(format string, args ...any) *CommandError {
+	return &CommandError{Msg: "ERR " + fmt.Sprintf(format, args...)}
+}
+
+func ErrWrongNumberOfArgs(name string) *CommandError {
+	return NewError("wrong number of arguments for '%s' command", name)
+}
+
+var (
+	ErrSyntax     = NewError("syntax error")
+	ErrNotInteger = NewError("value is not an integer or out of range")
+	ErrWrongType  = &CommandError{Msg: storage.ErrWrongType.Error()}
+)
+
+// AsCommandError returns the command error contained in err, if any. The
+// errors of the storage, like storage.ErrWrongType, are command errors too.
+func AsCommandError(err error) (*CommandError, bool) {
+	if errors.Is(err, storage.ErrWrongType) {
+		return ErrWrongType, true
+	}
+	var cmdErr *CommandError
+	ok := errors.As(err, &cmdErr)
+	return cmdErr, ok
+}
@@ -0,0 +1,156 @@
+package command
+
+import (
+	"context"
+	"math"
+	protocol "redisgo/protocol"
+	storage "redisgo/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT set the TTL of a key of any type.
+// Unit is the unit of the time argument and Absolute tells if it is a unix
+// time instead of a number of units from now.
+type Expire struct {
+	Storage  *storage.Storage
+	Unit     time.Duration
+	Absolute bool
+}
+
+func (e *Expire) Execute(args []string, ctx *context.Context, client *Client) error {
+	key := args[0]
+	t, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return ErrNotInteger
+	}
+	nx, xx, gt, lt, err := parseExpireOptions(args[2:])
+	if err != nil {
+		return err
+	}
+
+	at, ok := unixMilli(t, e.Unit, e.Absolute)
+	if !ok {
+		return NewError("invalid expire time in '%s' command", client.Cmd.Name)
+	}
+
+	var reply int64
+	expired := false
+	s := e.Storage
+	s.Do(func(tx *storage.Tx) error {
+		current := tx.ExpireTime(key)
+		if current == -2 {
+			return nil
+		}
+		// a key without TTL never expires, so its TTL is greater than any other
+		switch {
+		case nx && current != -1,
+			xx && current == -1,
+			gt && (current == -1 || at <= current),
+			lt && current != -1 && at >= current:
+			return nil
+		}
+
+		reply = 1
+		if at <= time.Now().UnixMilli() {
+			tx.DeleteValue(key)
+			expired = true
+			return nil
+		}
+		tx.SetExpire(key, time.UnixMilli(at))
+		return nil
+	})
+
+	// the replicas get an absolute time so they expire the key at the same
+	// time, or a DEL if the key was deleted right away
+	switch {
+	case reply == 0:
+		client.Flags |= ClientPreventPropagation
+	case expired:
+		client.Propagate(protocol.DEL, key)
+	default:
+		client.Propagate(protocol.PEXPIREAT, key, strconv.FormatInt(at, 10))
+	}
+	return client.Writer.WriteInt(reply)
+}
+
+// unixMilli converts t, a number of units from now or a unix time in units if
+// absolute is set, to a unix time in milliseconds. It returns false if the
+// time overflows.
+func unixMilli(t int64, unit time.Duration, absolute bool) (int64, bool) {
+	n := int64(unit / time.Millisecond)
+	if t > math.MaxInt64/n || t < math.MinInt64/n {
+		return 0, false
+	}
+	at := t * n
+	if !absolute {
+		now := time.Now().UnixMilli()
+		if at > math.MaxInt64-now {
+			return 0, false
+		}
+		at += now
+	}
+	return at, true
+}
+
+func parseExpireOptions(args []string) (nx, xx, gt, lt bool, err error) {
+	for _, arg := range args {
+		switch strings.ToLower(arg) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		default:
+			return false, false, false, false, NewError("Unsupported option %s", arg)
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return false, false, false, false, NewError("NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return false, false, false, false, NewError("GT and LT options at the same time are not compatible")
+	}
+	return nx, xx, gt, lt, nil
+}
+
+// TTL, PTTL, EXPIRETIME and PEXPIRETIME reply with the TTL of a key in Unit,
+// or with the unix time at which it expires if Absolute is set. They reply -1
+// if the key has no TTL and -2 if it doesn't exist.
+type TTL struct {
+	Storage  *storage.Storage
+	Unit     time.Duration
+	Absolute bool
+}
+
+func (t *TTL) Execute(args []string, ctx *context.Context, client *Client) error {
+	at := t.Storage.ExpireTime(args[0])
+	if at < 0 {
+		return client.Writer.WriteInt(at)
+	}
+
+	unit := int64(t.Unit / time.Millisecond)
+	if t.Absolute {
+		return client.Writer.WriteInt(at / unit)
+	}
+	// the TTL is rounded to the closest unit
+	ttl := max(at-time.Now().UnixMilli(), 0)
+	return client.Writer.WriteInt((ttl + unit/2) / unit)
+}
+
+// PERSIST removes the TTL of a key
+type Persist struct {
+	Storage *storage.Storage
+}
+
+func (p *Persist) Execute(args []string, ctx *context.Context, client *Client) error {
+	if !p.Storage.Persist(args[0]) {
+		client.Flags |= ClientPreventPropagation
+		return client.Writer.WriteInt(0)
+	}
+	return client.Writer.WriteInt(1)
+}
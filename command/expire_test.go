@@ -0,0 +1,87 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"redisgo/protocol"
+	"redisgo/storage"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpireCommands(t *testing.T) {
+	s := storage.NewStorage()
+	propagated := []string{}
+	table := NewTable(
+		&Command{Name: protocol.EXPIRE, Arity: -3, Flags: FlagWrite, Handler: &Expire{Storage: s, Unit: time.Second}},
+		&Command{Name: protocol.PEXPIREAT, Arity: -3, Flags: FlagWrite, Handler: &Expire{Storage: s, Unit: time.Millisecond, Absolute: true}},
+		&Command{Name: protocol.TTL, Arity: 2, Handler: &TTL{Storage: s, Unit: time.Second}},
+		&Command{Name: protocol.PEXPIRETIME, Arity: 2, Handler: &TTL{Storage: s, Unit: time.Millisecond, Absolute: true}},
+		&Command{Name: protocol.PERSIST, Arity: 2, Flags: FlagWrite, Handler: &Persist{Storage: s}},
+	)
+	table.Use(Propagate(PropagatorFunc(func(args []string) {
+		propagated = append(propagated, strings.Join(args, " "))
+	})))
+
+	var buf bytes.Buffer
+	client := &Client{Writer: protocol.NewWriter(&buf)}
+	ctx := context.Background()
+	run := func(name string, args ...string) error {
+		cmd, _ := table.Lookup(name)
+		client.Cmd = cmd
+		return cmd.Execute(args, &ctx, client)
+	}
+
+	s.Set("str", "value")
+	s.AppendValuesToList("list", "a")
+	at := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+
+	run(protocol.TTL, "list")
+	run(protocol.TTL, "missing")
+	run(protocol.EXPIRE, "missing", "10")
+	run(protocol.EXPIRE, "list", "100", "XX")
+	run(protocol.EXPIRE, "list", "100", "GT")
+	run(protocol.EXPIRE, "list", "100", "NX")
+	run(protocol.EXPIRE, "list", "200", "NX")
+	run(protocol.EXPIRE, "list", "50", "GT")
+	run(protocol.EXPIRE, "list", "200", "GT")
+	run(protocol.TTL, "list")
+	run(protocol.PEXPIREAT, "str", at, "LT")
+	run(protocol.PEXPIRETIME, "str")
+	run(protocol.PERSIST, "str")
+	run(protocol.PERSIST, "str")
+	// a time in the past deletes the key
+	run(protocol.EXPIRE, "list", "-1")
+	run(protocol.TTL, "list")
+	client.Writer.Flush()
+
+	expected := ":-1\r\n:-2\r\n:0\r\n:0\r\n:0\r\n:1\r\n:0\r\n:0\r\n:1\r\n:200\r\n:1\r\n:" + at + "\r\n:1\r\n:0\r\n:1\r\n:-2\r\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	// the relative times are propagated as absolute times
+	if len(propagated) != 5 {
+		t.Fatalf("expected 5 propagated commands, got %q", propagated)
+	}
+	for i, prefix := range []string{"pexpireat list ", "pexpireat list ", "pexpireat str " + at, "persist str", "del list"} {
+		if !strings.HasPrefix(propagated[i], prefix) {
+			t.Errorf("expected %q to be propagated, got %q", prefix, propagated[i])
+		}
+	}
+
+	errors := map[string][]string{
+		"ERR value is not an integer or out of range":                         {"str", "x"},
+		"ERR Unsupported option FOO":                                          {"str", "10", "FOO"},
+		"ERR NX and XX, GT or LT options at the same time are not compatible": {"str", "10", "NX", "GT"},
+		"ERR GT and LT options at the same time are not compatible":           {"str", "10", "GT", "LT"},
+		"ERR invalid expire time in 'expire' command":                         {"str", "9223372036854775807"},
+	}
+	for msg, args := range errors {
+		if err := run(protocol.EXPIRE, args...); err == nil || err.Error() != msg {
+			t.Errorf("%q: expected %q, got %v", args, msg, err)
+		}
+	}
+}
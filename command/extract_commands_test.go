@@ -2,6 +2,7 @@ package command
 
 import (
 	"redisgo/protocol"
+	"strings"
 	"testing"
 )
 
@@ -30,10 +31,13 @@ func TestExtractCommands(t *testing.T) {
 		{input: []string{"INFO", "memory"},
 			expected: Cmd{protocol.INFO, []string{"memory"}},
 		},
+		{input: []string{"HELLO", "3", "AUTH", "default", "secret", "SETNAME", "app"},
+			expected: Cmd{protocol.HELLO, []string{"3", "AUTH", "default", "secret", "SETNAME", "app"}},
+		},
 	}
 
 	for i, c := range cases {
-		commands, err := ExtractCommandsFromParsedData(c.input)
+		commands, err := ExtractCommands([]protocol.Value{protocol.BulkStringArray(c.input)})
 		if err != nil {
 			t.Errorf("case [%d]: %v", i, err)
 		}
@@ -60,3 +64,62 @@ func TestExtractCommands(t *testing.T) {
 
 	}
 }
+
+func TestExtractPipelinedCommands(t *testing.T) {
+	input := [][]string{
+		{"RPUSH", "a", "1", "2"},
+		{"GET", "b"},
+		{"XADD", "s", "*", "field", "value"},
+		{"LRANGE", "a", "0", "-1"},
+		{"PING"},
+	}
+
+	values := make([]protocol.Value, len(input))
+	for i, args := range input {
+		values[i] = protocol.BulkStringArray(args)
+	}
+
+	commands, err := ExtractCommands(values)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(commands) != len(input) {
+		t.Fatalf("expected %d commands, got %d", len(input), len(commands))
+	}
+
+	for i, cmd := range commands {
+		if cmd.Name != strings.ToLower(input[i][0]) {
+			t.Errorf("command [%d]: expected name %s, got %s", i, input[i][0], cmd.Name)
+		}
+		if len(cmd.Args) != len(input[i])-1 {
+			t.Errorf("command [%d]: expected %d arguments, got %d", i, len(input[i])-1, len(cmd.Args))
+		}
+	}
+}
+
+func TestExtractCommandsRejectsInvalidElements(t *testing.T) {
+	cases := map[string]protocol.Value{
+		"Protocol error: invalid bulk length": {Type: protocol.ARRAY, Array: []protocol.Value{{Type: protocol.BULK_STRINGS, Null: true}}},
+		"Protocol error: expected '$', got '*'": {Type: protocol.ARRAY, Array: []protocol.Value{
+			protocol.BulkStringArray([]string{"GET", "foo"}),
+		}},
+		"Protocol error: expected '$', got ':'": {Type: protocol.ARRAY, Array: []protocol.Value{
+			{Type: protocol.BULK_STRINGS, Str: "GET"}, {Type: protocol.INTEGERS, Int: 1},
+		}},
+	}
+	for expected, value := range cases {
+		if _, err := ExtractCommands([]protocol.Value{value}); err == nil || err.Error() != expected {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	}
+
+	// empty arrays are skipped
+	commands, err := ExtractCommands([]protocol.Value{
+		{Type: protocol.ARRAY},
+		protocol.BulkStringArray([]string{"PING"}),
+	})
+	if err != nil || len(commands) != 1 || commands[0].Name != protocol.PING {
+		t.Errorf("expected the empty array to be skipped, got %v, %v", commands, err)
+	}
+}
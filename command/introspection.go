@@ -0,0 +1,252 @@
+package command
+
+import (
+	"context"
+	protocol "redisgo/protocol"
+	"strings"
+)
+
+var commandHelp = []string{
+	"COMMAND <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"(no subcommand)",
+	"    Return details about all commands.",
+	"COUNT",
+	"    Return the total number of commands in this server.",
+	"LIST",
+	"    Return a list of all commands in this server.",
+	"INFO [<command-name> ...]",
+	"    Return details about multiple commands.",
+	"    If no command names are given, documentation details for all",
+	"    commands are returned.",
+	"DOCS [<command-name> ...]",
+	"    Return documentation details about multiple commands.",
+	"    If no command names are given, documentation details for all",
+	"    commands are returned.",
+	"GETKEYS <full-command>",
+	"    Return the keys from a full command.",
+	"HELP",
+	"    Print this help.",
+}
+
+// COMMAND describes the commands registered in Table, clients use it to
+// discover the arity, flags and keys of the commands
+type CommandCmd struct {
+	Table *Table
+}
+
+func (c *CommandCmd) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
+	if len(args) == 0 {
+		return c.writeInfo(w, c.Table.Commands())
+	}
+
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "count" && len(args) == 1:
+		return w.WriteInt(int64(len(c.Table.Commands())))
+
+	case sub == "list" && len(args) == 1:
+		commands := c.Table.Commands()
+		w.WriteArrayHeader(len(commands))
+		for _, cmd := range commands {
+			w.WriteBulk(cmd.Name)
+		}
+		return nil
+
+	case sub == "info":
+		if len(args) == 1 {
+			return c.writeInfo(w, c.Table.Commands())
+		}
+		w.WriteArrayHeader(len(args) - 1)
+		for _, name := range args[1:] {
+			cmd, ok := c.Table.Lookup(strings.ToLower(name))
+			if !ok {
+				w.WriteNull()
+				continue
+			}
+			writeCommandInfo(w, cmd)
+		}
+		return nil
+
+	case sub == "docs":
+		commands := c.Table.Commands()
+		if len(args) > 1 {
+			commands = commands[:0]
+			for _, name := range args[1:] {
+				// unknown commands are left out of the reply
+				if cmd, ok := c.Table.Lookup(strings.ToLower(name)); ok {
+					commands = append(commands, cmd)
+				}
+			}
+		}
+		w.WriteMapHeader(len(commands))
+		for _, cmd := range commands {
+			w.WriteBulk(cmd.Name)
+			writeCommandDocs(w, cmd)
+		}
+		return nil
+
+	case sub == "getkeys" && len(args) > 1:
+		cmd, ok := c.Table.Lookup(strings.ToLower(args[1]))
+		if !ok {
+			return NewError("Invalid command specified")
+		}
+		if cmd.Validate(args[2:]) != nil {
+			return NewError("Invalid number of arguments specified for command")
+		}
+		keys := cmd.Keys(args[2:])
+		if len(keys) == 0 {
+			return NewError("The command has no key arguments")
+		}
+		return w.WriteBulkArray(keys)
+
+	case sub == "help" && len(args) == 1:
+		w.WriteArrayHeader(len(commandHelp))
+		for _, line := range commandHelp {
+			w.WriteSimpleString(line)
+		}
+		return nil
+
+	default:
+		return NewError("unknown subcommand or wrong number of arguments for '%s'. Try COMMAND HELP.", args[0])
+	}
+}
+
+func (c *CommandCmd) writeInfo(w *protocol.Writer, commands []*Command) error {
+	w.WriteArrayHeader(len(commands))
+	for _, cmd := range commands {
+		writeCommandInfo(w, cmd)
+	}
+	return nil
+}
+
+// writeCommandInfo writes the 10 elements reply used by COMMAND and COMMAND INFO:
+// name, arity, flags, first key, last key, step, ACL categories, tips, key
+// specs and subcommands
+func writeCommandInfo(w *protocol.Writer, cmd *Command) {
+	w.WriteArrayHeader(10)
+	w.WriteBulk(cmd.Name)
+	w.WriteInt(int64(cmd.Arity))
+
+	flags := cmd.Flags.Names()
+	if cmd.MovableKeys != nil {
+		flags = append(flags, "movablekeys")
+	}
+	writeSimpleStringSet(w, flags)
+
+	w.WriteInt(int64(cmd.FirstKey))
+	w.WriteInt(int64(cmd.LastKey))
+	w.WriteInt(int64(cmd.Step))
+	writeSimpleStringSet(w, aclCategories(cmd))
+
+	// tips
+	w.WriteSetHeader(0)
+
+	if cmd.FirstKey > 0 {
+		w.WriteArrayHeader(1)
+		writeKeySpec(w, cmd)
+	} else {
+		w.WriteArrayHeader(0)
+	}
+
+	// subcommands
+	w.WriteArrayHeader(0)
+}
+
+// writeKeySpec describes the keys of a command with fixed key positions
+func writeKeySpec(w *protocol.Writer, cmd *Command) {
+	flags := []string{"RO", "ACCESS"}
+	if cmd.HasFlag(FlagWrite) {
+		flags = []string{"RW", "UPDATE"}
+	}
+
+	// the last key of the find_keys spec is relative to the first key
+	lastKey := cmd.LastKey
+	if lastKey >= 0 {
+		lastKey -= cmd.FirstKey
+	}
+
+	w.WriteMapHeader(3)
+	w.WriteBulk("flags")
+	writeSimpleStringSet(w, flags)
+
+	w.WriteBulk("begin_search")
+	w.WriteMapHeader(2)
+	w.WriteBulk("type")
+	w.WriteBulk("index")
+	w.WriteBulk("spec")
+	w.WriteMapHeader(1)
+	w.WriteBulk("index")
+	w.WriteInt(int64(cmd.FirstKey))
+
+	w.WriteBulk("find_keys")
+	w.WriteMapHeader(2)
+	w.WriteBulk("type")
+	w.WriteBulk("range")
+	w.WriteBulk("spec")
+	w.WriteMapHeader(3)
+	w.WriteBulk("lastkey")
+	w.WriteInt(int64(lastKey))
+	w.WriteBulk("keystep")
+	w.WriteInt(int64(max(cmd.Step, 1)))
+	w.WriteBulk("limit")
+	w.WriteInt(0)
+}
+
+func writeCommandDocs(w *protocol.Writer, cmd *Command) {
+	fields := [][2]string{}
+	if cmd.Summary != "" {
+		fields = append(fields, [2]string{"summary", cmd.Summary})
+	}
+	if cmd.Since != "" {
+		fields = append(fields, [2]string{"since", cmd.Since})
+	}
+	if cmd.Group != "" {
+		fields = append(fields, [2]string{"group", cmd.Group})
+	}
+
+	w.WriteMapHeader(len(fields))
+	for _, f := range fields {
+		w.WriteBulk(f[0])
+		w.WriteBulk(f[1])
+	}
+}
+
+// aclCategories returns the categories of a command derived from its flags
+// and group, like redis does for "@write", "@fast" or "@list"
+func aclCategories(cmd *Command) []string {
+	categories := []string{}
+	if cmd.HasFlag(FlagWrite) {
+		categories = append(categories, "@write")
+	}
+	if cmd.HasFlag(FlagReadonly) {
+		categories = append(categories, "@read")
+	}
+	switch cmd.Group {
+	case "generic":
+		categories = append(categories, "@keyspace")
+	case "string", "list", "stream", "connection", "scripting":
+		categories = append(categories, "@"+cmd.Group)
+	case "transactions":
+		categories = append(categories, "@transaction")
+	}
+	if cmd.HasFlag(FlagAdmin) {
+		categories = append(categories, "@admin", "@dangerous")
+	}
+	if cmd.HasFlag(FlagFast) {
+		categories = append(categories, "@fast")
+	} else {
+		categories = append(categories, "@slow")
+	}
+	if cmd.HasFlag(FlagBlocking) {
+		categories = append(categories, "@blocking")
+	}
+	return categories
+}
+
+func writeSimpleStringSet(w *protocol.Writer, items []string) {
+	w.WriteSetHeader(len(items))
+	for _, item := range items {
+		w.WriteSimpleString(item)
+	}
+}
@@ -0,0 +1,105 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"redisgo/protocol"
+	"testing"
+)
+
+func newIntrospectionTable() *Table {
+	table := NewTable(
+		&Command{Name: protocol.GET, Arity: 2, Flags: FlagReadonly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1, Group: "string"},
+		&Command{Name: protocol.BLPOP, Arity: -3, Flags: FlagWrite | FlagBlocking, FirstKey: 1, LastKey: -2, Step: 1, Group: "list"},
+		&Command{Name: protocol.XREAD, Arity: -4, Flags: FlagReadonly | FlagBlocking, MovableKeys: XReadKeys, Group: "stream"},
+		&Command{Name: protocol.PING, Arity: -1, Flags: FlagFast, Summary: "Returns the server's liveliness response.", Since: "1.0.0", Group: "connection"},
+	)
+	table.Register(&Command{Name: "command", Arity: -1, Handler: &CommandCmd{Table: table}, Group: "server"})
+	return table
+}
+
+func execCommand(t *testing.T, table *Table, version int, args ...string) (string, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := protocol.NewWriter(&buf)
+	w.Protocol = version
+	cmd, _ := table.Lookup("command")
+	ctx := context.Background()
+	err := cmd.Handler.Execute(args, &ctx, &Client{Writer: w})
+	w.Flush()
+	return buf.String(), err
+}
+
+func TestCommandIntrospection(t *testing.T) {
+	table := newIntrospectionTable()
+	cases := []struct {
+		args     []string
+		version  int
+		expected string
+	}{
+		{[]string{"COUNT"}, protocol.RESP2, ":5\r\n"},
+		{[]string{"LIST"}, protocol.RESP2, "*5\r\n$5\r\nblpop\r\n$7\r\ncommand\r\n$3\r\nget\r\n$4\r\nping\r\n$5\r\nxread\r\n"},
+		{
+			[]string{"INFO", "get", "nosuchcommand"}, protocol.RESP2,
+			"*2\r\n*10\r\n$3\r\nget\r\n:2\r\n*2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n*3\r\n+@read\r\n+@string\r\n+@fast\r\n*0\r\n" +
+				"*1\r\n*6\r\n$5\r\nflags\r\n*2\r\n+RO\r\n+ACCESS\r\n" +
+				"$12\r\nbegin_search\r\n*4\r\n$4\r\ntype\r\n$5\r\nindex\r\n$4\r\nspec\r\n*2\r\n$5\r\nindex\r\n:1\r\n" +
+				"$9\r\nfind_keys\r\n*4\r\n$4\r\ntype\r\n$5\r\nrange\r\n$4\r\nspec\r\n*6\r\n$7\r\nlastkey\r\n:0\r\n$7\r\nkeystep\r\n:1\r\n$5\r\nlimit\r\n:0\r\n" +
+				"*0\r\n$-1\r\n",
+		},
+		{
+			[]string{"INFO", "XREAD"}, protocol.RESP3,
+			"*1\r\n*10\r\n$5\r\nxread\r\n:-4\r\n~3\r\n+readonly\r\n+blocking\r\n+movablekeys\r\n:0\r\n:0\r\n:0\r\n~4\r\n+@read\r\n+@stream\r\n+@slow\r\n+@blocking\r\n~0\r\n*0\r\n*0\r\n",
+		},
+		{
+			[]string{"DOCS", "ping", "nosuchcommand"}, protocol.RESP3,
+			"%1\r\n$4\r\nping\r\n%3\r\n$7\r\nsummary\r\n$41\r\nReturns the server's liveliness response.\r\n$5\r\nsince\r\n$5\r\n1.0.0\r\n$5\r\ngroup\r\n$10\r\nconnection\r\n",
+		},
+		{[]string{"GETKEYS", "GET", "foo"}, protocol.RESP2, "*1\r\n$3\r\nfoo\r\n"},
+		{[]string{"GETKEYS", "BLPOP", "a", "b", "0"}, protocol.RESP2, "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"GETKEYS", "XREAD", "COUNT", "2", "STREAMS", "s1", "s2", "0", "0"}, protocol.RESP2, "*2\r\n$2\r\ns1\r\n$2\r\ns2\r\n"},
+	}
+
+	for i, c := range cases {
+		out, err := execCommand(t, table, c.version, c.args...)
+		if err != nil {
+			t.Errorf("case [%d]: unexpected error: %v", i, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("case [%d]: expected %q, got %q", i, c.expected, out)
+		}
+	}
+}
+
+func TestCommandGetKeysErrors(t *testing.T) {
+	table := newIntrospectionTable()
+	cases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"GETKEYS", "nosuchcommand", "foo"}, "ERR Invalid command specified"},
+		{[]string{"GETKEYS", "GET"}, "ERR Invalid number of arguments specified for command"},
+		{[]string{"GETKEYS", "PING"}, "ERR The command has no key arguments"},
+		{[]string{"FOO"}, "ERR unknown subcommand or wrong number of arguments for 'FOO'. Try COMMAND HELP."},
+	}
+
+	for i, c := range cases {
+		_, err := execCommand(t, table, protocol.RESP2, c.args...)
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("case [%d]: expected error %q, got %v", i, c.expected, err)
+		}
+	}
+}
+
+func TestCommandInfoIsValidResp(t *testing.T) {
+	table := newIntrospectionTable()
+	parser := protocol.RedisProtocolParser{}
+	for _, version := range []int{protocol.RESP2, protocol.RESP3} {
+		out, _ := execCommand(t, table, version)
+		values, err := parser.Decode([]byte(out))
+		if err != nil || len(values) != 1 || len(values[0].Array) != 5 {
+			t.Errorf("RESP%d: invalid COMMAND reply %q: %v", version, out, err)
+		}
+	}
+}
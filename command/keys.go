@@ -0,0 +1,164 @@
+package command
+
+import (
+	"context"
+	storage "redisgo/storage"
+	"strconv"
+	"strings"
+)
+
+// DEL and UNLINK remove keys of any type. The values are reclaimed by the
+// garbage collector in the background, so both commands do the same.
+type Del struct {
+	Storage *storage.Storage
+}
+
+func (d *Del) Execute(args []string, ctx *context.Context, client *Client) error {
+	n := d.Storage.Delete(args...)
+	if n == 0 {
+		client.Flags |= ClientPreventPropagation
+	}
+	return client.Writer.WriteInt(int64(n))
+}
+
+// EXISTS and TOUCH reply with the number of keys that exist
+type Exists struct {
+	Storage *storage.Storage
+}
+
+func (e *Exists) Execute(args []string, ctx *context.Context, client *Client) error {
+	return client.Writer.WriteInt(int64(e.Storage.Exists(args...)))
+}
+
+// RENAME and RENAMENX move a key with its TTL, RENAMENX fails if the new
+// name exists
+type Rename struct {
+	Storage *storage.Storage
+	NX      bool
+}
+
+func (r *Rename) Execute(args []string, ctx *context.Context, client *Client) error {
+	renamed, err := r.Storage.Rename(args[0], args[1], !r.NX)
+	if err != nil {
+		return &CommandError{Msg: err.Error()}
+	}
+	if !r.NX {
+		return client.Writer.WriteOK()
+	}
+	if !renamed {
+		client.Flags |= ClientPreventPropagation
+		return client.Writer.WriteInt(0)
+	}
+	return client.Writer.WriteInt(1)
+}
+
+// COPY source destination [DB index] [REPLACE]
+type Copy struct {
+	Storage *storage.Storage
+}
+
+func (c *Copy) Execute(args []string, ctx *context.Context, client *Client) error {
+	replace := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "replace":
+			replace = true
+		case "db":
+			if i+1 == len(args) {
+				return ErrSyntax
+			}
+			i++
+			db, err := strconv.Atoi(args[i])
+			if err != nil {
+				return ErrNotInteger
+			}
+			// there is a single database
+			if db != 0 {
+				return NewError("DB index is out of range")
+			}
+		default:
+			return ErrSyntax
+		}
+	}
+
+	copied, err := c.Storage.Copy(args[0], args[1], replace)
+	if err != nil {
+		return &CommandError{Msg: err.Error()}
+	}
+	if !copied {
+		client.Flags |= ClientPreventPropagation
+		return client.Writer.WriteInt(0)
+	}
+	return client.Writer.WriteInt(1)
+}
+
+// RANDOMKEY
+type RandomKey struct {
+	Storage *storage.Storage
+}
+
+func (r *RandomKey) Execute(args []string, ctx *context.Context, client *Client) error {
+	key, ok := r.Storage.RandomKey()
+	if !ok {
+		return client.Writer.WriteNull()
+	}
+	return client.Writer.WriteBulk(key)
+}
+
+// KEYS pattern
+type Keys struct {
+	Storage *storage.Storage
+}
+
+func (k *Keys) Execute(args []string, ctx *context.Context, client *Client) error {
+	return client.Writer.WriteBulkArray(k.Storage.Keys(args[0]))
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+type Scan struct {
+	Storage *storage.Storage
+}
+
+func (s *Scan) Execute(args []string, ctx *context.Context, client *Client) error {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return NewError("invalid cursor")
+	}
+
+	count, pattern, typ := 10, "", storage.TypeNone
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return ErrSyntax
+		}
+		value := args[i+1]
+		switch strings.ToLower(args[i]) {
+		case "match":
+			// every key matches a single star, it isn't checked
+			if value != "*" {
+				pattern = value
+			}
+		case "count":
+			count, err = strconv.Atoi(value)
+			if err != nil {
+				return ErrNotInteger
+			}
+			if count < 1 {
+				return ErrSyntax
+			}
+		case "type":
+			var ok bool
+			typ, ok = storage.ParseType(strings.ToLower(value))
+			if !ok || typ == storage.TypeNone {
+				return NewError("unknown type name '%s'", value)
+			}
+		default:
+			return ErrSyntax
+		}
+	}
+
+	next, keys := s.Storage.Scan(cursor, count, pattern, typ)
+	w := client.Writer
+	w.WriteArrayHeader(2)
+	w.WriteBulk(strconv.FormatUint(next, 10))
+	return w.WriteBulkArray(keys)
+}
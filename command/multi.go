@@ -0,0 +1,116 @@
+package command
+
+import (
+	"context"
+	protocol "redisgo/protocol"
+	storage "redisgo/storage"
+)
+
+// MULTI starts a transaction, the following commands are queued by the
+// dispatcher until EXEC or DISCARD
+type Multi struct{}
+
+func (m *Multi) Execute(args []string, ctx *context.Context, client *Client) error {
+	if client.HasFlag(ClientMulti) {
+		return NewError("MULTI calls can not be nested")
+	}
+	client.Flags |= ClientMulti
+	return client.Writer.WriteOK()
+}
+
+// DISCARD
+type Discard struct{}
+
+func (d *Discard) Execute(args []string, ctx *context.Context, client *Client) error {
+	if !client.HasFlag(ClientMulti) {
+		return NewError("DISCARD without MULTI")
+	}
+	client.discardTransaction()
+	client.Unwatch()
+	return client.Writer.WriteOK()
+}
+
+// EXEC runs the queued commands and replies with an array that contains the
+// reply of each one. The dispatcher runs EXEC alone, so no other command can
+// see the data in the middle of the transaction.
+type Exec struct{}
+
+func (e *Exec) Execute(args []string, ctx *context.Context, client *Client) error {
+	w := client.Writer
+	if !client.HasFlag(ClientMulti) {
+		return NewError("EXEC without MULTI")
+	}
+	queue := client.queue
+	dirty := client.HasFlag(ClientDirtyExec)
+	client.discardTransaction()
+	if dirty {
+		client.Unwatch()
+		return &CommandError{Msg: "EXECABORT Transaction discarded because of previous errors."}
+	}
+
+	// a watched key was modified since WATCH, the transaction is not executed
+	casFailed := client.watch != nil && client.watch.Dirty()
+	client.Unwatch()
+	if casFailed {
+		return w.WriteNullArray()
+	}
+
+	exec := client.Cmd
+	client.Flags |= ClientDenyBlocking
+	defer func() {
+		client.Cmd = exec
+		client.Flags &^= ClientDenyBlocking
+	}()
+
+	// the replicas apply the transaction atomically too
+	client.PropagateTransaction()
+
+	// errors of single commands are part of the reply, the rest of the
+	// commands are executed anyway
+	w.WriteArrayHeader(len(queue))
+	for _, q := range queue {
+		client.Cmd = q.cmd
+		err := q.cmd.Execute(q.args, ctx, client)
+		if cmdErr, ok := AsCommandError(err); ok {
+			err = w.WriteError(cmdErr.Msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsTransactionCommand reports whether the command controls the transaction
+// instead of being queued after MULTI
+func IsTransactionCommand(name string) bool {
+	switch name {
+	case protocol.MULTI, protocol.EXEC, protocol.DISCARD, protocol.WATCH:
+		return true
+	}
+	return false
+}
+
+// WATCH marks keys to make the next EXEC fail if any of them is modified
+type Watch struct {
+	Storage *storage.Storage
+}
+
+func (h *Watch) Execute(args []string, ctx *context.Context, client *Client) error {
+	if client.HasFlag(ClientMulti) {
+		return NewError("WATCH inside MULTI is not allowed")
+	}
+	if client.watch == nil {
+		client.watch = h.Storage.NewWatch()
+	}
+	client.watch.Add(args...)
+	return client.Writer.WriteOK()
+}
+
+// UNWATCH
+type Unwatch struct{}
+
+func (u *Unwatch) Execute(args []string, ctx *context.Context, client *Client) error {
+	client.Unwatch()
+	return client.Writer.WriteOK()
+}
@@ -0,0 +1,78 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"redisgo/protocol"
+	"testing"
+)
+
+func TestExecRunsQueuedCommands(t *testing.T) {
+	value := ""
+	set := &Command{Name: protocol.SET, Arity: 3, Flags: FlagWrite, Handler: HandlerFunc(func(args []string, ctx *context.Context, client *Client) error {
+		value = args[1]
+		return client.Writer.WriteOK()
+	})}
+	fail := &Command{Name: "fail", Arity: 1, Handler: HandlerFunc(func(args []string, ctx *context.Context, client *Client) error {
+		return ErrSyntax
+	})}
+	exec := &Command{Name: protocol.EXEC, Arity: 1, Handler: &Exec{}}
+
+	var buf bytes.Buffer
+	client := &Client{Writer: protocol.NewWriter(&buf)}
+	ctx := context.Background()
+
+	(&Multi{}).Execute(nil, &ctx, client)
+	client.Queue(set, []string{"foo", "bar"})
+	client.Queue(fail, nil)
+	client.Queue(set, []string{"foo", "baz"})
+	if value != "" {
+		t.Fatalf("queued commands must not be executed before EXEC")
+	}
+
+	client.Cmd = exec
+	if err := exec.Execute(nil, &ctx, client); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client.Writer.Flush()
+
+	expected := "+OK\r\n*3\r\n+OK\r\n-ERR syntax error\r\n+OK\r\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+	if value != "baz" {
+		t.Errorf("expected all the commands to run, got value %q", value)
+	}
+	if client.HasFlag(ClientMulti) || client.Cmd != exec {
+		t.Errorf("the client must leave the transaction state after EXEC")
+	}
+}
+
+func TestTransactionErrors(t *testing.T) {
+	var buf bytes.Buffer
+	client := &Client{Writer: protocol.NewWriter(&buf)}
+	ctx := context.Background()
+
+	cases := []struct {
+		handler  CommandHandler
+		setup    func()
+		expected string
+	}{
+		{&Exec{}, func() {}, "ERR EXEC without MULTI"},
+		{&Discard{}, func() {}, "ERR DISCARD without MULTI"},
+		{&Multi{}, func() { client.Flags |= ClientMulti }, "ERR MULTI calls can not be nested"},
+		{&Exec{}, func() { client.Flags |= ClientMulti | ClientDirtyExec }, "EXECABORT Transaction discarded because of previous errors."},
+	}
+
+	for i, c := range cases {
+		client.discardTransaction()
+		c.setup()
+		err := c.handler.Execute(nil, &ctx, client)
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("case [%d]: expected error %q, got %v", i, c.expected, err)
+		}
+	}
+	if client.HasFlag(ClientMulti) {
+		t.Errorf("EXECABORT must discard the transaction")
+	}
+}
@@ -0,0 +1,70 @@
+package command
+
+import (
+	"context"
+	protocol "redisgo/protocol"
+)
+
+// Propagator receives the write commands executed by the server, it is the
+// hook used to feed replicas and persistence logs
+type Propagator interface {
+	Propagate(args []string)
+}
+
+// PropagatorFunc allows the use of ordinary functions as propagators
+type PropagatorFunc func(args []string)
+
+func (f PropagatorFunc) Propagate(args []string) {
+	f(args)
+}
+
+// Propagate returns a middleware that sends to p the commands flagged as
+// write that succeed, or the commands passed to client.Propagate by them.
+// Commands that fail with a CommandError and commands that set ClientPreventPropagation are not
+// propagated. The commands executed by another command, like the ones queued
+// in a transaction or called by a script, are propagated with the outer
+// command, see Client.PropagateTransaction.
+func Propagate(p Propagator) Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return HandlerFunc(func(args []string, ctx *context.Context, client *Client) error {
+			outer, nested, transaction := client.propagated, client.propagating, client.transaction
+			client.propagated = nil
+			client.propagating = true
+			client.transaction = false
+			client.Flags &^= ClientPreventPropagation
+
+			err := next.Execute(args, ctx, client)
+
+			propagated, atomic := client.propagated, client.transaction
+			client.propagated = outer
+			client.propagating = nested
+			client.transaction = transaction
+			// an I/O error writing the reply doesn't undo the command
+			_, failed := AsCommandError(err)
+			prevented := client.HasFlag(ClientPreventPropagation)
+			client.Flags &^= ClientPreventPropagation
+			if (failed || prevented) && !atomic {
+				return err
+			}
+			if propagated == nil && !atomic && client.Cmd != nil && client.Cmd.HasFlag(FlagWrite) {
+				propagated = [][]string{append([]string{client.Cmd.Name}, args...)}
+			}
+			if atomic && len(propagated) > 0 && !nested && client.parent == nil {
+				propagated = append([][]string{{protocol.MULTI}}, propagated...)
+				propagated = append(propagated, []string{protocol.EXEC})
+			}
+
+			switch {
+			case nested:
+				client.propagated = append(client.propagated, propagated...)
+			case client.parent != nil:
+				client.parent.propagated = append(client.parent.propagated, propagated...)
+			default:
+				for _, c := range propagated {
+					p.Propagate(c)
+				}
+			}
+			return err
+		})
+	}
+}
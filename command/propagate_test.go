@@ -0,0 +1,97 @@
+package command
+
+import (
+	"context"
+	"io"
+	"redisgo/protocol"
+	"strings"
+	"testing"
+)
+
+func TestPropagateMiddleware(t *testing.T) {
+	propagated := []string{}
+	table := NewTable(
+		&Command{Name: protocol.GET, Arity: 2, Flags: FlagReadonly, Handler: HandlerFunc(func(args []string, ctx *context.Context, client *Client) error {
+			return nil
+		})},
+		&Command{Name: protocol.SET, Arity: -3, Flags: FlagWrite, Handler: HandlerFunc(func(args []string, ctx *context.Context, client *Client) error {
+			if args[1] == "fail" {
+				return ErrSyntax
+			}
+			if args[1] == "same" {
+				client.Flags |= ClientPreventPropagation
+			}
+			return nil
+		})},
+		&Command{Name: "incrnow", Arity: 2, Flags: FlagWrite, Handler: HandlerFunc(func(args []string, ctx *context.Context, client *Client) error {
+			client.Propagate("set", args[0], "42")
+			client.Propagate("pexpire", args[0], "1000")
+			return nil
+		})},
+	)
+	table.Use(Propagate(PropagatorFunc(func(args []string) {
+		propagated = append(propagated, strings.Join(args, " "))
+	})))
+
+	ctx := context.Background()
+	client := &Client{}
+	run := func(name string, args ...string) {
+		cmd, _ := table.Lookup(name)
+		client.Cmd = cmd
+		cmd.Execute(args, &ctx, client)
+	}
+
+	run(protocol.GET, "foo")
+	run(protocol.SET, "foo", "bar")
+	run(protocol.SET, "foo", "fail")
+	run(protocol.SET, "foo", "same")
+	run("incrnow", "foo")
+	run(protocol.SET, "foo", "baz")
+
+	expected := []string{"set foo bar", "set foo 42", "pexpire foo 1000", "set foo baz"}
+	if strings.Join(propagated, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %q, got %q", expected, propagated)
+	}
+}
+
+func TestPropagateTransaction(t *testing.T) {
+	propagated := []string{}
+	table := NewTable(
+		&Command{Name: protocol.SET, Arity: -3, Flags: FlagWrite, Handler: HandlerFunc(func(args []string, ctx *context.Context, client *Client) error {
+			if args[1] == "fail" {
+				return ErrSyntax
+			}
+			return nil
+		})},
+		&Command{Name: protocol.GET, Arity: 2, Flags: FlagReadonly, Handler: HandlerFunc(func(args []string, ctx *context.Context, client *Client) error {
+			return nil
+		})},
+		&Command{Name: protocol.EXEC, Arity: 1, Handler: &Exec{}},
+	)
+	table.Use(Propagate(PropagatorFunc(func(args []string) {
+		propagated = append(propagated, strings.Join(args, " "))
+	})))
+
+	ctx := context.Background()
+	client := &Client{Writer: protocol.NewWriter(io.Discard)}
+	exec := func(commands ...[]string) {
+		client.Flags |= ClientMulti
+		for _, c := range commands {
+			cmd, _ := table.Lookup(c[0])
+			client.Queue(cmd, c[1:])
+		}
+		cmd, _ := table.Lookup(protocol.EXEC)
+		client.Cmd = cmd
+		cmd.Execute(nil, &ctx, client)
+	}
+
+	// only the commands that succeed are propagated, inside MULTI/EXEC
+	exec([]string{protocol.SET, "a", "1"}, []string{protocol.GET, "a"}, []string{protocol.SET, "b", "fail"}, []string{protocol.SET, "b", "2"})
+	// a transaction without writes isn't propagated
+	exec([]string{protocol.GET, "a"})
+
+	expected := []string{"multi", "set a 1", "set b 2", "exec"}
+	if strings.Join(propagated, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %q, got %q", expected, propagated)
+	}
+}
@@ -0,0 +1,174 @@
+package command
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// Flag describes the behaviour of a command
+type Flag uint
+
+const (
+	FlagWrite    Flag = 1 << iota // may modify the dataset
+	FlagReadonly                  // only reads data
+	FlagBlocking                  // may block the client
+	FlagAdmin                     // administrative or replication command
+	FlagFast                      // runs in constant or log time
+	FlagNoScript                  // can't be called from scripts
+)
+
+var flagNames = []struct {
+	flag Flag
+	name string
+}{
+	{FlagWrite, "write"},
+	{FlagReadonly, "readonly"},
+	{FlagBlocking, "blocking"},
+	{FlagAdmin, "admin"},
+	{FlagFast, "fast"},
+	{FlagNoScript, "noscript"},
+}
+
+// Names returns the names of the flags as reported by COMMAND INFO
+func (f Flag) Names() []string {
+	names := []string{}
+	for _, n := range flagNames {
+		if f&n.flag != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+// Command declares a command supported by the server. Arity includes the
+// command name, a negative value means that the command takes at least that
+// number of arguments (-3 -> 3 or more). FirstKey, LastKey and Step are the
+// positions of the keys in the arguments, also counting the command name,
+// a negative LastKey counts from the end (-1 is the last argument) and a
+// FirstKey of 0 means that the command doesn't take keys.
+// Group, Summary and Since are only used by COMMAND DOCS.
+type Command struct {
+	Name     string
+	Arity    int
+	Flags    Flag
+	FirstKey int
+	LastKey  int
+	Step     int
+	Handler  CommandHandler
+
+	// MovableKeys returns the keys of commands whose keys don't have fixed
+	// positions, like XREAD where they follow the STREAMS option
+	MovableKeys func(args []string) []string
+
+	Group   string
+	Summary string
+	Since   string
+
+	// chain is Handler wrapped by the middlewares of the table
+	chain CommandHandler
+}
+
+// Validate checks the number of arguments of the command, args doesn't
+// include the command name
+func (c *Command) Validate(args []string) error {
+	argc := len(args) + 1
+	if (c.Arity > 0 && argc != c.Arity) || (c.Arity < 0 && argc < -c.Arity) {
+		return ErrWrongNumberOfArgs(c.Name)
+	}
+	return nil
+}
+
+// Execute runs the handler of the command through the middlewares of the
+// table it is registered in
+func (c *Command) Execute(args []string, ctx *context.Context, client *Client) error {
+	if c.chain != nil {
+		return c.chain.Execute(args, ctx, client)
+	}
+	return c.Handler.Execute(args, ctx, client)
+}
+
+func (c *Command) HasFlag(f Flag) bool {
+	return c.Flags&f != 0
+}
+
+// Keys returns the keys in args, which must have a valid number of arguments
+func (c *Command) Keys(args []string) []string {
+	if c.MovableKeys != nil {
+		return c.MovableKeys(args)
+	}
+	if c.FirstKey <= 0 {
+		return nil
+	}
+
+	last := c.LastKey
+	if last < 0 {
+		last += len(args) + 1
+	}
+	step := max(c.Step, 1)
+	keys := []string{}
+	for i := c.FirstKey; i <= last && i <= len(args); i += step {
+		keys = append(keys, args[i-1])
+	}
+	return keys
+}
+
+// Table is the registry of the commands supported by the server
+type Table struct {
+	commands    map[string]*Command
+	middlewares []Middleware
+}
+
+func NewTable(commands ...*Command) *Table {
+	t := &Table{commands: make(map[string]*Command, len(commands))}
+	for _, c := range commands {
+		t.Register(c)
+	}
+	return t
+}
+
+// Register adds a command to the table, registering the same name twice is a
+// programming error and panics
+func (t *Table) Register(c *Command) {
+	c.Name = strings.ToLower(c.Name)
+	if _, ok := t.commands[c.Name]; ok {
+		panic("command: duplicate registration of " + c.Name)
+	}
+	c.chain = t.wrap(c.Handler)
+	t.commands[c.Name] = c
+}
+
+// Use adds middlewares that run around the handler of every command, the
+// first middleware is the outermost one. It must be called before the server
+// starts handling connections.
+func (t *Table) Use(middlewares ...Middleware) {
+	t.middlewares = append(t.middlewares, middlewares...)
+	for _, c := range t.commands {
+		c.chain = t.wrap(c.Handler)
+	}
+}
+
+func (t *Table) wrap(handler CommandHandler) CommandHandler {
+	for i := len(t.middlewares) - 1; i >= 0; i-- {
+		handler = t.middlewares[i](handler)
+	}
+	return handler
+}
+
+// Lookup returns the command with the given lowercase name
+func (t *Table) Lookup(name string) (*Command, bool) {
+	c, ok := t.commands[name]
+	return c, ok
+}
+
+// Commands returns all the registered commands sorted by name
+func (t *Table) Commands() []*Command {
+	commands := make([]*Command, 0, len(t.commands))
+	for _, c := range t.commands {
+		commands = append(commands, c)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands
+}
@@ -0,0 +1,125 @@
+package command
+
+import (
+	"context"
+	"redisgo/protocol"
+	"strings"
+	"testing"
+)
+
+func TestValidateArity(t *testing.T) {
+	table := NewTable(
+		&Command{Name: protocol.PING, Arity: -1},
+		&Command{Name: protocol.GET, Arity: 2},
+		&Command{Name: protocol.SET, Arity: -3},
+		&Command{Name: protocol.LLEN, Arity: 2},
+	)
+
+	cases := []struct {
+		cmd   Cmd
+		valid bool
+	}{
+		{Cmd{protocol.GET, []string{"foo"}}, true},
+		{Cmd{Name: protocol.GET}, false},
+		{Cmd{protocol.GET, []string{"foo", "bar"}}, false},
+		{Cmd{Name: protocol.LLEN}, false},
+		{Cmd{protocol.SET, []string{"foo"}}, false},
+		{Cmd{protocol.SET, []string{"foo", "bar", "EX", "10"}}, true},
+		{Cmd{Name: protocol.PING}, true},
+		{Cmd{protocol.PING, []string{"hello"}}, true},
+	}
+
+	for i, c := range cases {
+		command, ok := table.Lookup(c.cmd.Name)
+		if !ok {
+			t.Fatalf("case [%d]: command %s not found", i, c.cmd.Name)
+		}
+		err := command.Validate(c.cmd.Args)
+		if c.valid && err != nil {
+			t.Errorf("case [%d]: unexpected error: %v", i, err)
+		}
+		if !c.valid {
+			if err == nil {
+				t.Errorf("case [%d]: expected wrong number of arguments error", i)
+			} else if err.Error() != "ERR wrong number of arguments for '"+c.cmd.Name+"' command" {
+				t.Errorf("case [%d]: unexpected error message: %v", i, err)
+			}
+		}
+	}
+}
+
+func TestTableRegister(t *testing.T) {
+	table := NewTable(
+		&Command{Name: "SET", Arity: -3, Flags: FlagWrite},
+		&Command{Name: "get", Arity: 2, Flags: FlagReadonly | FlagFast},
+	)
+
+	get, ok := table.Lookup("get")
+	if !ok || !get.HasFlag(FlagFast) || get.HasFlag(FlagWrite) {
+		t.Errorf("unexpected command %+v", get)
+	}
+	if _, ok := table.Lookup("set"); !ok {
+		t.Errorf("command names must be stored in lowercase")
+	}
+
+	commands := table.Commands()
+	if len(commands) != 2 || commands[0].Name != "get" || commands[1].Name != "set" {
+		t.Errorf("expected commands sorted by name, got %+v", commands)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic registering a duplicated command")
+		}
+	}()
+	table.Register(&Command{Name: "get", Arity: 2})
+}
+
+func TestTableMiddlewares(t *testing.T) {
+	calls := []string{}
+	record := func(name string) Middleware {
+		return func(next CommandHandler) CommandHandler {
+			return HandlerFunc(func(args []string, ctx *context.Context, client *Client) error {
+				calls = append(calls, name+":"+client.Cmd.Name)
+				return next.Execute(args, ctx, client)
+			})
+		}
+	}
+	denyWrites := func(next CommandHandler) CommandHandler {
+		return HandlerFunc(func(args []string, ctx *context.Context, client *Client) error {
+			if client.Cmd.HasFlag(FlagWrite) {
+				return &CommandError{Msg: "READONLY You can't write against a read only replica."}
+			}
+			return next.Execute(args, ctx, client)
+		})
+	}
+	handler := HandlerFunc(func(args []string, ctx *context.Context, client *Client) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+
+	table := NewTable(&Command{Name: protocol.GET, Arity: 2, Flags: FlagReadonly, Handler: handler})
+	table.Use(record("outer"), record("inner"))
+	// commands registered after Use are wrapped too
+	table.Register(&Command{Name: protocol.SET, Arity: -3, Flags: FlagWrite, Handler: handler})
+	table.Use(denyWrites)
+
+	ctx := context.Background()
+	for _, name := range []string{protocol.GET, protocol.SET} {
+		cmd, _ := table.Lookup(name)
+		client := &Client{Cmd: cmd}
+		err := cmd.Execute([]string{"foo", "bar"}, &ctx, client)
+		if name == protocol.SET {
+			if cmdErr, ok := AsCommandError(err); !ok || cmdErr.Msg[:8] != "READONLY" {
+				t.Errorf("expected READONLY error, got %v", err)
+			}
+		} else if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	expected := []string{"outer:get", "inner:get", "handler", "outer:set", "inner:set"}
+	if strings.Join(calls, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+}
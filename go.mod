@@ -5,3 +5,5 @@ go 1.24.0
 require github.com/google/uuid v1.6.0
 
 require github.com/AntonyChR/go-utils v0.6.0
+
+require github.com/yuin/gopher-lua v1.1.1
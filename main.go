@@ -1,62 +1,29 @@
 package main
 
 import (
-	"context"
 	"flag"
-	command "redisgo/command"
-	network "redisgo/network"
-	utils "redisgo/utils"
+	"log"
 	protocol "redisgo/protocol"
-	redis "redisgo/redis"
-	storage "redisgo/storage"
+	server "redisgo/server"
 )
 
 var SERVER_PORT = flag.String("port", "6379", "Port to listen on")
 var REPLICA_OF = flag.String("replicaof", "", "Replicate to another server")
+var PROTO_MAX_BULK_LEN = flag.Int("proto-max-bulk-len", protocol.DefaultLimits.MaxBulkLen, "Max size of a bulk string in a request")
+var MAX_MULTIBULK_LEN = flag.Int("max-multibulk-len", protocol.DefaultLimits.MaxMultibulkLen, "Max number of elements of an array in a request")
 
 func main() {
 	flag.Parse()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	srv := server.NewServer(server.Options{
+		Addr: ":" + *SERVER_PORT,
+		Limits: protocol.Limits{
+			MaxBulkLen:      *PROTO_MAX_BULK_LEN,
+			MaxMultibulkLen: *MAX_MULTIBULK_LEN,
+		},
+	})
 
-	storage := storage.NewStorage()
-
-	p := &protocol.RedisProtocolParser{}
-	replicaChan := make(chan []byte)
-
-	handlers := make(map[string]command.CommandHandler)
-	handlers[protocol.PING] = &command.PingHandler{}
-	handlers[protocol.ECHO] = &command.EchoHandler{Parser: p}
-	handlers[protocol.GET] = &command.GetHandler{Storage: storage, Parser: p}
-	handlers[protocol.SET] = &command.SetHandler{Storage: storage, ReplicaChan: replicaChan}
-	handlers[protocol.RPUSH] = &command.RPush{Storage: storage}
-	handlers[protocol.LRANGE] = &command.LRange{Storage: storage, Parser: p}
-	handlers[protocol.LPUSH] = &command.LPush{Storage: storage}
-	handlers[protocol.LLEN] = &command.LLEN{Storage: storage}
-	handlers[protocol.LPOP] = &command.LPOP{Storage: storage, Parser: p}
-	handlers[protocol.BLPOP] = &command.BLPOP{Storage: storage, Parser: p}
-	handlers[protocol.TYPE] = &command.Type{Storage: storage, Parser: p}
-	handlers[protocol.XADD] = &command.XAdd{Storage: storage, Parser: p}
-	handlers[protocol.XRANGE] = &command.XRange{Storage: storage, Parser: p}
-	handlers[protocol.XREAD] = &command.XRead{Storage: storage, Parser: p}
-
-	server, _ := network.CreateNewServer(*SERVER_PORT, "master", "")
-
-	instanceInfo := redis.InstanceInfo{
-		Port: *SERVER_PORT,
-		Id: utils.GenerateUUID(),
-		Offset: 0, 
+	if err := srv.ListenAndServe(); err != nil {
+		log.Fatal(err)
 	}
-
-	redis := redis.Redis{
-		Server:   server,
-		Handlers: handlers,
-		Parser:   p,
-		Info:     &instanceInfo,
-		Ctx:      ctx,
-	}
-
-	redis.Start()
-
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 )
 
 // server roles
@@ -37,11 +38,19 @@ func CreateNewServer(port string, role string, masterAddr string) (*TcpServer, e
 
 }
 
+// ErrServerClosed is returned by Serve after a call to Shutdown
+var ErrServerClosed = errors.New("network: server closed")
+
 type TcpServer struct {
 	port                 string
 	RegisterNewSlaveChan chan string
-	conn                 map[string]net.Conn
 	context              context.Context
+
+	mu       sync.Mutex
+	listener net.Listener
+	conn     map[string]net.Conn
+	wg       sync.WaitGroup
+	closed   bool
 }
 
 func (s *TcpServer) Start(handleConn func(net.Conn)) error {
@@ -49,25 +58,100 @@ func (s *TcpServer) Start(handleConn func(net.Conn)) error {
 	if err != nil {
 		return err
 	}
-	defer listener.Close()
+	return s.Serve(listener, handleConn)
+}
 
-	errChan := make(chan error, 1)
+// Serve accepts connections on listener and handles each one in its own
+// goroutine until Shutdown is called. The listener is closed when Serve returns.
+func (s *TcpServer) Serve(listener net.Listener, handleConn func(net.Conn)) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+	defer listener.Close()
 
-	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				errChan <- err
-				return
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
 			}
-			go handleConn(conn)
+			return err
 		}
+
+		if !s.track(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrack(conn)
+			handleConn(conn)
+		}()
+	}
+}
+
+// Addr returns the address the server is listening on, or nil if it is not serving yet
+func (s *TcpServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Shutdown stops accepting connections, closes the open ones and waits for
+// their handlers to return or for ctx to be done
+func (s *TcpServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for _, conn := range s.conn {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
 	}()
 
 	select {
-	case <-s.context.Done():
+	case <-done:
 		return nil
-	case err := <-errChan:
-		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
+
+func (s *TcpServer) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// track registers an open connection, it returns false if the server is shutting down
+func (s *TcpServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conn[conn.RemoteAddr().String()] = conn
+	s.wg.Add(1)
+	return true
+}
+
+func (s *TcpServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conn, conn.RemoteAddr().String())
+	s.mu.Unlock()
+	s.wg.Done()
+}
@@ -0,0 +1,142 @@
+package protocol
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// examples taken from the RESP2 and RESP3 specifications
+var conformanceCases = []struct {
+	name     string
+	input    string
+	expected Value
+}{
+	{"simple string", "+OK\r\n", SimpleString("OK")},
+	{"simple error", "-Error message\r\n", Error("Error message")},
+	{"error with code", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+	{"integer", ":1000\r\n", Integer(1000)},
+	{"zero", ":0\r\n", Integer(0)},
+	{"negative integer", ":-1000\r\n", Integer(-1000)},
+	{"signed integer", ":+1000\r\n", Integer(1000)},
+	{"bulk string", "$5\r\nhello\r\n", BulkString("hello")},
+	{"empty bulk string", "$0\r\n\r\n", BulkString("")},
+	{"binary bulk string", "$6\r\nfoo\r\nb\r\n", BulkString("foo\r\nb")},
+	{"null bulk string", "$-1\r\n", NullBulkString()},
+	{"empty array", "*0\r\n", Array()},
+	{"array of bulk strings", "*2\r\n$5\r\nhello\r\n$5\r\nworld\r\n", BulkStringArray([]string{"hello", "world"})},
+	{"array of integers", "*3\r\n:1\r\n:2\r\n:3\r\n", Array(Integer(1), Integer(2), Integer(3))},
+	{"mixed array", "*5\r\n:1\r\n:2\r\n:3\r\n:4\r\n$5\r\nhello\r\n", Array(Integer(1), Integer(2), Integer(3), Integer(4), BulkString("hello"))},
+	{"null array", "*-1\r\n", NullArray()},
+	{"nested arrays", "*2\r\n*3\r\n:1\r\n:2\r\n:3\r\n*2\r\n+Hello\r\n-World\r\n", Array(Array(Integer(1), Integer(2), Integer(3)), Array(SimpleString("Hello"), Error("World")))},
+	{"null elements in arrays", "*3\r\n$5\r\nhello\r\n$-1\r\n$5\r\nworld\r\n", Array(BulkString("hello"), NullBulkString(), BulkString("world"))},
+	{"null", "_\r\n", Null()},
+	{"true", "#t\r\n", Boolean(true)},
+	{"false", "#f\r\n", Boolean(false)},
+	{"double", ",1.23\r\n", Double(1.23)},
+	{"integer double", ",10\r\n", Double(10)},
+	{"exponent double", ",1.5e3\r\n", Double(1500)},
+	{"positive infinity", ",inf\r\n", Double(math.Inf(1))},
+	{"negative infinity", ",-inf\r\n", Double(math.Inf(-1))},
+	{"big number", "(3492890328409238509324850943850943825024385\r\n", BigNumber("3492890328409238509324850943850943825024385")},
+	{"negative big number", "(-3492890328409238509324850943850943825024385\r\n", BigNumber("-3492890328409238509324850943850943825024385")},
+	{"bulk error", "!21\r\nSYNTAX invalid syntax\r\n", Value{Type: BULK_ERRORS, Str: "SYNTAX invalid syntax"}},
+	{"verbatim string", "=15\r\ntxt:Some string\r\n", VerbatimString("txt", "Some string")},
+	{"map", "%2\r\n+first\r\n:1\r\n+second\r\n:2\r\n", Map(SimpleString("first"), Integer(1), SimpleString("second"), Integer(2))},
+	{"empty map", "%0\r\n", Map()},
+	{"set", "~3\r\n+orange\r\n+apple\r\n#t\r\n", Set(SimpleString("orange"), SimpleString("apple"), Boolean(true))},
+	{"push", ">3\r\n+message\r\n+channel\r\n$5\r\nhello\r\n", Push(SimpleString("message"), SimpleString("channel"), BulkString("hello"))},
+	{"nested map", "%1\r\n+key\r\n*2\r\n:1\r\n_\r\n", Map(SimpleString("key"), Array(Integer(1), Null()))},
+	{"inline command", "PING\r\n", BulkStringArray([]string{"PING"})},
+}
+
+func TestConformanceDecode(t *testing.T) {
+	parser := RedisProtocolParser{}
+	for _, c := range conformanceCases {
+		values, err := parser.Decode([]byte(c.input))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if len(values) != 1 {
+			t.Errorf("%s: expected 1 value, got %d", c.name, len(values))
+			continue
+		}
+		if !reflect.DeepEqual(values[0], c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.expected, values[0])
+		}
+
+		n, err := FrameLength([]byte(c.input))
+		if err != nil || n != len(c.input) {
+			t.Errorf("%s: expected frame length %d, got %d (%v)", c.name, len(c.input), n, err)
+		}
+	}
+}
+
+// isResp2 reports whether the value only contains RESP2 types
+func isResp2(v Value) bool {
+	switch v.Type {
+	case SIMPLE_STRINGS, SIMPLE_ERRORS, INTEGERS, BULK_STRINGS:
+		return true
+	case ARRAY:
+		for _, e := range v.Array {
+			if !isResp2(e) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// TestConformanceEncode encodes the RESP2 examples with RESP2 and the RESP3 ones with RESP3
+func TestConformanceEncode(t *testing.T) {
+	parser := RedisProtocolParser{}
+	for _, c := range conformanceCases {
+		if c.name == "signed integer" || c.name == "inline command" || c.expected.Type == DOUBLES {
+			// these inputs have more than one valid representation
+			continue
+		}
+		version := RESP3
+		if isResp2(c.expected) {
+			version = RESP2
+		}
+		encoded := string(parser.EncodeValue(c.expected, version))
+		if encoded != c.input {
+			t.Errorf("%s: expected %q, got %q", c.name, c.input, encoded)
+		}
+	}
+}
+
+func TestConformanceInvalidInput(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+	}{
+		{"truncated bulk string", "$5\r\nab"},
+		{"negative bulk length", "$-2\r\n"},
+		{"non numeric bulk length", "$x\r\nab\r\n"},
+		{"missing bulk length", "$\r\n\r\n"},
+		{"bulk string longer than declared", "$2\r\nabc\r\n"},
+		{"negative array length", "*-5\r\n"},
+		{"non numeric array length", "*a\r\n"},
+		{"missing array elements", "*3\r\n:1\r\n"},
+		{"invalid integer", ":12a\r\n"},
+		{"invalid boolean", "#x\r\n"},
+		{"invalid double", ",abc\r\n"},
+		{"invalid type in array", "*1\r\n?\r\n"},
+		{"huge bulk length", "$9223372036854775807\r\nab\r\n"},
+		{"huge array length", "*9223372036854775807\r\n"},
+		{"unbalanced quotes", "SET \"foo\r\n"},
+	}
+
+	parser := RedisProtocolParser{}
+	for _, c := range cases {
+		_, err := parser.Decode([]byte(c.input))
+		if err == nil {
+			t.Errorf("%s: expected error for %q", c.name, c.input)
+		} else if !IsProtocolError(err) {
+			t.Errorf("%s: expected protocol error, got %v", c.name, err)
+		}
+	}
+}
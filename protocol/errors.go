@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"errors"
+)
+
+// ProtocolError is returned when the data sent by the client doesn't follow
+// the protocol. After a protocol error the start of the next frame is unknown,
+// so the connection has to be closed once the error is sent to the client.
+type ProtocolError struct {
+	Msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.Msg
+}
+
+func newProtocolError(msg string) error {
+	return &ProtocolError{Msg: msg}
+}
+
+// IsProtocolError reports whether err is caused by invalid data sent by the client
+func IsProtocolError(err error) bool {
+	var protocolErr *ProtocolError
+	return errors.As(err, &protocolErr)
+}
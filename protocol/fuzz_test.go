@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+var fuzzSeeds = []string{
+	"+OK\r\n",
+	"-ERR boom\r\n",
+	":-42\r\n",
+	"$5\r\nhello\r\n",
+	"$0\r\n\r\n",
+	"$-1\r\n",
+	"$5\r\nab",
+	"*-1\r\n",
+	"*0\r\n",
+	"*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n",
+	"*2\r\n*1\r\n:1\r\n*1\r\n+two\r\n",
+	"%1\r\n+key\r\n:1\r\n",
+	"~2\r\n#t\r\n#f\r\n",
+	">2\r\n+message\r\n$2\r\nhi\r\n",
+	",3.14\r\n",
+	",-inf\r\n",
+	"(3492890328409238509324850943850943825024385\r\n",
+	"=15\r\ntxt:Some string\r\n",
+	"!21\r\nSYNTAX invalid syntax\r\n",
+	"_\r\n",
+	"PING\r\n",
+	"SET foo \"bar \\x41\" 'baz'\n",
+	"*2147483647\r\n",
+	"$9999999999999999999\r\n",
+}
+
+// FuzzDecode checks that no input can make the decoder panic
+func FuzzDecode(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+	parser := RedisProtocolParser{}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parser.Decode(data)
+		FrameLength(data)
+	})
+}
+
+// FuzzRoundTrip checks that decoding the encoded form of a decoded value
+// returns the same value
+func FuzzRoundTrip(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+	parser := RedisProtocolParser{}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		values, err := parser.Decode(data)
+		if err != nil {
+			return
+		}
+		for _, v := range values {
+			encoded := parser.EncodeValue(v, RESP3)
+
+			n, err := FrameLength(encoded)
+			if err != nil || n != len(encoded) {
+				t.Fatalf("encoded value %q is not a single frame: %d, %v", encoded, n, err)
+			}
+
+			decoded, err := parser.Decode(encoded)
+			if err != nil {
+				t.Fatalf("error decoding %q: %v", encoded, err)
+			}
+			if len(decoded) != 1 || !bytes.Equal(parser.EncodeValue(decoded[0], RESP3), encoded) {
+				t.Fatalf("round trip mismatch for %q, got %+v", encoded, decoded)
+			}
+		}
+	})
+}
+
+// FuzzReader checks that reading the frames one byte at a time returns the
+// same frames as reading them from a single buffer
+func FuzzReader(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s + s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		expected := [][]byte{}
+		for rest := data; ; {
+			n, err := FrameLength(rest)
+			if err != nil {
+				break
+			}
+			expected = append(expected, rest[:n])
+			rest = rest[n:]
+		}
+
+		reader := NewReader(iotest.OneByteReader(bytes.NewReader(data)))
+		frames := [][]byte{}
+		for {
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				if err != io.EOF && err != io.ErrUnexpectedEOF && !IsProtocolError(err) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				break
+			}
+			frames = append(frames, bytes.Clone(frame))
+		}
+
+		if !reflect.DeepEqual(frames, expected) {
+			t.Fatalf("expected frames %q, got %q", expected, frames)
+		}
+	})
+}
+
+func FuzzSplitArgs(f *testing.F) {
+	f.Add(`SET key "hello world"`)
+	f.Add(`SET key 'it\'s'`)
+	f.Add(`"\x00\xff"`)
+	f.Fuzz(func(t *testing.T, line string) {
+		SplitArgs(line)
+	})
+}
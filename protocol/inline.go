@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+var errUnbalancedQuotes = newProtocolError("unbalanced quotes in request")
+
+// isRespType reports whether b is the type byte of a RESP value, any other byte
+// starts an inline command like "PING\r\n" or "SET foo bar\r\n"
+func isRespType(b byte) bool {
+	switch b {
+	case SIMPLE_STRINGS, SIMPLE_ERRORS, INTEGERS, BULK_STRINGS, ARRAY, NULLS, BOOLEANS,
+		DOUBLES, BIG_NUMBERS, BULK_ERRORS, VERBATIM_STRINGS, MAPS, SETS, PUSHES:
+		return true
+	}
+	return false
+}
+
+// inlineLength returns the length of the inline command at the beginning of
+// data including the line terminator, which can be "\r\n" or just "\n"
+func inlineLength(data []byte) (int, error) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return 0, ErrIncompleteFrame
+	}
+	return i + 1, nil
+}
+
+// parseInline parses an inline command and returns it as an array of bulk
+// strings, the same value a client sending "*3\r\n$3\r\nSET..." would produce
+func parseInline(data []byte) (Value, int, error) {
+	n, err := inlineLength(data)
+	if err != nil {
+		// the last line of the input doesn't need the terminator
+		n = len(data)
+	}
+	line := strings.TrimRight(string(data[:n]), "\r\n")
+	args, err := SplitArgs(line)
+	if err != nil {
+		return Value{}, 0, err
+	}
+	return BulkStringArray(args), n, nil
+}
+
+// SplitArgs splits a line into arguments using the same rules as redis-cli and
+// the inline protocol: arguments are separated by spaces and can be quoted.
+// Double quoted arguments support the escapes \n \r \t \b \a \\ \" and \xHH,
+// single quoted arguments only support \'.
+func SplitArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+	for {
+		// skip blanks
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var (
+			current  strings.Builder
+			inDouble bool
+			inSingle bool
+			done     bool
+		)
+		for !done {
+			if i >= len(line) {
+				if inDouble || inSingle {
+					return nil, errUnbalancedQuotes
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDouble:
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					current.WriteByte(byte(b))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						current.WriteByte('\n')
+					case 'r':
+						current.WriteByte('\r')
+					case 't':
+						current.WriteByte('\t')
+					case 'b':
+						current.WriteByte('\b')
+					case 'a':
+						current.WriteByte('\a')
+					default:
+						current.WriteByte(line[i])
+					}
+				} else if c == '"' {
+					// the closing quote must be followed by a space or nothing
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					current.WriteByte(c)
+				}
+			case inSingle:
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					current.WriteByte('\'')
+					i++
+				} else if c == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					current.WriteByte(c)
+				}
+			default:
+				switch c {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					current.WriteByte(c)
+				}
+			}
+			i++
+		}
+		args = append(args, current.String())
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+	}{
+		{"PING", []string{"PING"}},
+		{"  SET   foo bar  ", []string{"SET", "foo", "bar"}},
+		{`SET key "hello world"`, []string{"SET", "key", "hello world"}},
+		{`SET key "a\r\nb\t\"c\"\\"`, []string{"SET", "key", "a\r\nb\t\"c\"\\"}},
+		{`SET key "\x00\xff\x41"`, []string{"SET", "key", "\x00\xffA"}},
+		{`SET key 'it\'s "raw" \n'`, []string{"SET", "key", `it's "raw" \n`}},
+		{`SET key ""`, []string{"SET", "key", ""}},
+		{"", []string{}},
+	}
+
+	for i, c := range cases {
+		args, err := SplitArgs(c.input)
+		if err != nil {
+			t.Errorf("case [%d]: unexpected error: %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, c.expected) {
+			t.Errorf("case [%d]: expected %q, got %q", i, c.expected, args)
+		}
+	}
+}
+
+func TestSplitArgsUnbalancedQuotes(t *testing.T) {
+	cases := []string{
+		`SET key "hello`,
+		`SET key 'hello`,
+		`SET key "hello"world`,
+		`SET key 'hello'world`,
+	}
+
+	for i, c := range cases {
+		if _, err := SplitArgs(c); err == nil {
+			t.Errorf("case [%d]: expected error for %q", i, c)
+		}
+	}
+}
+
+func TestDecodeInlineCommands(t *testing.T) {
+	input := "PING\r\nSET foo \"bar baz\"\n\r\n*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"
+	expected := []Value{
+		BulkStringArray([]string{"PING"}),
+		BulkStringArray([]string{"SET", "foo", "bar baz"}),
+		BulkStringArray([]string{"GET", "foo"}),
+	}
+
+	parser := RedisProtocolParser{}
+	values, err := parser.Decode([]byte(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %+v, got %+v", expected, values)
+	}
+
+	lengths := []int{6, 18, 2}
+	data := []byte(input)
+	for i, l := range lengths {
+		n, err := FrameLength(data)
+		if err != nil || n != l {
+			t.Errorf("frame [%d]: expected length %d, got %d (%v)", i, l, n, err)
+		}
+		data = data[n:]
+	}
+}
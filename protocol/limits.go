@@ -0,0 +1,43 @@
+package protocol
+
+// Limits bounds the size of the frames accepted by the Reader. They are
+// checked as soon as a length header is read, before the buffer grows to hold
+// the payload, so a client can't make the server allocate unbounded memory by
+// sending something like "*2147483647\r\n" or "$999999999\r\n".
+// A zero field means that the default value is used.
+type Limits struct {
+	// MaxBulkLen is the maximum length of a bulk string (proto-max-bulk-len)
+	MaxBulkLen int
+	// MaxMultibulkLen is the maximum number of elements of an array, set,
+	// push or map
+	MaxMultibulkLen int
+	// MaxInlineLen is the maximum length of an inline command and of any
+	// other line, like the length header of a bulk string
+	MaxInlineLen int
+	// MaxDepth is the maximum nesting level of aggregate types
+	MaxDepth int
+}
+
+// DefaultLimits are the same defaults used by redis
+var DefaultLimits = Limits{
+	MaxBulkLen:      512 * 1024 * 1024,
+	MaxMultibulkLen: 1024 * 1024,
+	MaxInlineLen:    64 * 1024,
+	MaxDepth:        32,
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxBulkLen <= 0 {
+		l.MaxBulkLen = DefaultLimits.MaxBulkLen
+	}
+	if l.MaxMultibulkLen <= 0 {
+		l.MaxMultibulkLen = DefaultLimits.MaxMultibulkLen
+	}
+	if l.MaxInlineLen <= 0 {
+		l.MaxInlineLen = DefaultLimits.MaxInlineLen
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = DefaultLimits.MaxDepth
+	}
+	return l
+}
@@ -2,38 +2,77 @@ package protocol
 
 import (
 	"bytes"
+	"fmt"
+	"math"
 	"strconv"
 )
 
 // comands
 const (
-	GET        = "get"
-	SET        = "set"
-	RPUSH      = "rpush"
-	LPUSH      = "lpush"
-	LRANGE     = "lrange"
-	LLEN       = "llen"
-	LPOP       = "lpop"
-	BLPOP      = "blpop"
-	TYPE       = "type"
-	XADD       = "xadd"
-	XRANGE     = "xrange"
-	XREAD      = "xread"
-	INFO       = "info"
-	PING       = "ping"
-	ECHO       = "echo"
-	PSYNC      = "psync"
-	REPLCONF   = "replconf"
-	FULLRESYNC = "fullresync"
-	WAIT       = "wait"
+	GET         = "get"
+	SET         = "set"
+	RPUSH       = "rpush"
+	LPUSH       = "lpush"
+	LRANGE      = "lrange"
+	LLEN        = "llen"
+	LPOP        = "lpop"
+	BLPOP       = "blpop"
+	TYPE        = "type"
+	XADD        = "xadd"
+	XRANGE      = "xrange"
+	XREAD       = "xread"
+	INFO        = "info"
+	PING        = "ping"
+	ECHO        = "echo"
+	HELLO       = "hello"
+	PSYNC       = "psync"
+	REPLCONF    = "replconf"
+	FULLRESYNC  = "fullresync"
+	WAIT        = "wait"
+	MULTI       = "multi"
+	EXEC        = "exec"
+	DISCARD     = "discard"
+	WATCH       = "watch"
+	UNWATCH     = "unwatch"
+	EVAL        = "eval"
+	EVALSHA     = "evalsha"
+	SCRIPT      = "script"
+	FUNCTION    = "function"
+	FCALL       = "fcall"
+	FCALL_RO    = "fcall_ro"
+	EXPIRE      = "expire"
+	PEXPIRE     = "pexpire"
+	EXPIREAT    = "expireat"
+	PEXPIREAT   = "pexpireat"
+	TTL         = "ttl"
+	PTTL        = "pttl"
+	EXPIRETIME  = "expiretime"
+	PEXPIRETIME = "pexpiretime"
+	PERSIST     = "persist"
+	DEL         = "del"
+	UNLINK      = "unlink"
+	EXISTS      = "exists"
+	TOUCH       = "touch"
+	RENAME      = "rename"
+	RENAMENX    = "renamenx"
+	COPY        = "copy"
+	RANDOMKEY   = "randomkey"
+	KEYS        = "keys"
+	SCAN        = "scan"
 )
 
-const ENDL string ="\r\n"
+const ENDL string = "\r\n"
 
 // set params
 const (
-	EX = "ex" // seconds
-	PX = "px" // milliseconds
+	EX   = "ex"   // seconds
+	PX   = "px"   // milliseconds
+	EXAT = "exat" // unix time in seconds
+	PXAT = "pxat" // unix time in milliseconds
+
+	// hello params
+	AUTH    = "auth"
+	SETNAME = "setname"
 
 	// temporal values, TODO: move to a config file
 	BASE64_EMPTY_RDB_FILE = "UkVESVMwMDEx+glyZWRpcy12ZXIFNy4yLjD6CnJlZGlzLWJpdHPAQPoFY3RpbWXCbQi8ZfoIdXNlZC1tZW3CsMQQAPoIYW9mLWJhc2XAAP/wbjv+wP9aog=="
@@ -59,78 +98,179 @@ const (
 var END_LINE = []byte("\r\n")
 
 // this function tranform redis protocol data like "*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\n123\r\n*3\r\n$3\r\nSET\r\n$3\r\nbar\r\n$3\r\n456"
-// into a slice of strings like ["SET", "foo", "123", "SET", "bar", "456"]
-func parseData(data []byte) []string {
-	splittedData := bytes.Split(bytes.TrimSuffix(data, END_LINE), END_LINE)
-	result := make([]string, 0)
-	for i := 0; i < len(splittedData); i++ {
+// into a slice of top-level values like [["SET", "foo", "123"], ["SET", "bar", "456"]]
+// The lengths and the nesting are checked against l like the Reader does, so
+// untrusted data can't exhaust the memory or the stack.
+func parseData(data []byte, l Limits) ([]Value, error) {
+	l = l.withDefaults()
+	result := make([]Value, 0)
+	for pos := 0; pos < len(data); {
+
+		// skip empty lines between frames
+		if bytes.HasPrefix(data[pos:], END_LINE) {
+			pos += len(END_LINE)
+			continue
+		}
 
-		if len(splittedData[i]) == 0 {
+		// commands sent by telnet or nc like "SET foo bar\r\n"
+		if !isRespType(data[pos]) {
+			value, n, err := parseInline(data[pos:])
+			if err != nil {
+				return nil, err
+			}
+			if len(value.Array) > 0 {
+				result = append(result, value)
+			}
+			pos += n
 			continue
 		}
 
-		switch splittedData[i][0] {
-		case SIMPLE_STRINGS:
-			result = append(result, parseSimpleString(splittedData[i:]))
-		case BULK_STRINGS:
-			str, n := parseBulkString(splittedData[i:])
-			result = append(result, str)
-			i += n - 1 // - 1 because the loop will increment i by 1
-		case ARRAY:
-			arr, n := parseArray(splittedData[i:])
-			result = append(result, arr...)
-			i += n - 1
+		value, n, err := parseValue(data[pos:], l, 0)
+		if err != nil {
+			return nil, err
 		}
+		result = append(result, value)
+		pos += n
+	}
+	return result, nil
+}
+
+// parseValue parses the value at the beginning of data and returns it with the number of bytes consumed,
+// depth is the number of aggregates that contain it
+func parseValue(data []byte, l Limits, depth int) (Value, int, error) {
+	switch data[0] {
+	case SIMPLE_STRINGS, SIMPLE_ERRORS, BIG_NUMBERS:
+		return parseSimpleString(data)
+	case INTEGERS:
+		return parseInteger(data)
+	case DOUBLES:
+		return parseDoubleValue(data)
+	case BOOLEANS:
+		return parseBoolean(data)
+	case NULLS:
+		_, n := parseLine(data)
+		return Null(), n, nil
+	case BULK_STRINGS, BULK_ERRORS, VERBATIM_STRINGS:
+		return parseBulkString(data, l)
+	case ARRAY, SETS, PUSHES, MAPS:
+		return parseArray(data, l, depth)
+	default:
+		return Value{}, 0, newProtocolError(fmt.Sprintf("unexpected type byte '%c'", data[0]))
+	}
+}
+
+// parseLine returns the content of the line that starts at the beginning of data (without the type byte)
+// and the number of bytes consumed including the CRLF terminator. The terminator is optional at the end of the input.
+func parseLine(data []byte) (string, int) {
+	i := bytes.Index(data, END_LINE)
+	if i < 0 {
+		return string(data[1:]), len(data)
 	}
-	return result
+	return string(data[1:i]), i + len(END_LINE)
+}
+
+// parseSimpleString parses simple strings, simple errors and big numbers, all of them are a single line
+func parseSimpleString(data []byte) (Value, int, error) {
+	str, n := parseLine(data)
+	return Value{Type: data[0], Str: str}, n, nil
 }
 
-func parseSimpleString(data [][]byte) string {
-	return string(data[0][1:])
+func parseInteger(data []byte) (Value, int, error) {
+	str, n := parseLine(data)
+	i, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return Value{}, 0, newProtocolError("invalid integer")
+	}
+	return Integer(i), n, nil
 }
 
-// parseBulkString parses a bulk string from the given data and returns the string value and the number of elements consumed.
-// The data parameter is a 2D byte slice where the first element represents the length of the bulk string and the second element represents the actual string data.
-// The function returns the parsed string value and the number of elements consumed (2 in this case).
-func parseBulkString(data [][]byte) (string, int) {
-	bulkLength, _ := strconv.Atoi(string(data[0][1:]))
-	return string(data[1][0:bulkLength]), 2
+func parseDoubleValue(data []byte) (Value, int, error) {
+	str, n := parseLine(data)
+	f, err := parseDouble(str)
+	if err != nil {
+		return Value{}, 0, newProtocolError("invalid double")
+	}
+	return Double(f), n, nil
 }
 
-// parseArray parses an array from the given data and returns the array and the number of elements consumed.
-// returns consumed because we need to know how many elements we have consumed in the data slice
-func parseArray(data [][]byte) (arr []string, consumed int) {
-	//[0] *3    -> data[0][1:] = arrLength = 3
-	//[1] $5    -> since data[1][0] = $, it's a bulk string
-	//[2] hello
-	//[3] $5
-	//[4] world
-	//[5] $5
-	//[6] again
-	arrLength, _ := strconv.Atoi(string(data[0][1:]))
-	arr = make([]string, 0)
-	consumed = 1 // we have consumed the first element which is the array length
-	count := 0
-	for i := 1; i < len(data); i++ {
-
-		// if count == arrLength, it means we have consumed all the elements in the array
-		if count == arrLength {
-			break
+func parseBoolean(data []byte) (Value, int, error) {
+	str, n := parseLine(data)
+	switch str {
+	case "t":
+		return Boolean(true), n, nil
+	case "f":
+		return Boolean(false), n, nil
+	default:
+		return Value{}, 0, newProtocolError("invalid boolean")
+	}
+}
+
+// parseBulkString parses a bulk string (or a bulk error or verbatim string) from the beginning of data and returns the value and the number of bytes consumed.
+// The payload is read using the declared length, so it can contain CRLF or any other binary content.
+func parseBulkString(data []byte, l Limits) (Value, int, error) {
+	lengthStr, n := parseLine(data)
+	bulkLength, err := strconv.Atoi(lengthStr)
+	if err != nil || bulkLength < -1 || bulkLength > l.MaxBulkLen {
+		return Value{}, 0, newProtocolError("invalid bulk length")
+	}
+	if bulkLength == -1 {
+		return Value{Type: data[0], Null: true}, n, nil
+	}
+
+	if bulkLength > len(data)-n {
+		return Value{}, 0, newProtocolError(fmt.Sprintf("bulk string too short, expected %d bytes, got %d", bulkLength, len(data)-n))
+	}
+	str := string(data[n : n+bulkLength])
+	n += bulkLength
+
+	// the final CRLF can be missing when the bulk string is the last element, e.g. the RDB payload sent to replicas
+	if bytes.HasPrefix(data[n:], END_LINE) {
+		n += len(END_LINE)
+	} else if n < len(data) {
+		return Value{}, 0, newProtocolError("expected CRLF after bulk string")
+	}
+	return Value{Type: data[0], Str: str}, n, nil
+}
+
+// parseArray parses an array (or a set, push or map) from the beginning of data and returns the array and the number of bytes consumed.
+// returns consumed because we need to know where the next element starts in data
+func parseArray(data []byte, l Limits, depth int) (arr Value, consumed int, err error) {
+	//*3\r\n          -> arrLength = 3
+	//$5\r\nhello\r\n -> since the type byte is $, it's a bulk string
+	//$5\r\nworld\r\n
+	//$5\r\nagain\r\n
+	lengthStr, consumed := parseLine(data)
+	arrLength, err := strconv.Atoi(lengthStr)
+	if err != nil || arrLength < -1 || arrLength > math.MaxInt32 {
+		return Value{}, 0, newProtocolError("invalid multibulk length")
+	}
+	if arrLength == -1 {
+		return Value{Type: data[0], Null: true}, consumed, nil
+	}
+	if depth >= l.MaxDepth {
+		return Value{}, 0, newProtocolError("too many nested aggregates")
+	}
+	if data[0] == MAPS {
+		arrLength *= 2
+	}
+	if arrLength > l.MaxMultibulkLen {
+		return Value{}, 0, newProtocolError("invalid multibulk length")
+	}
+
+	// the smallest element ("_\r\n") takes 3 bytes, a declared length bigger
+	// than that is not trusted for the allocation
+	elements := make([]Value, 0, min(arrLength, (len(data)-consumed)/3))
+	for count := 0; count < arrLength; count++ {
+		if consumed >= len(data) {
+			return Value{}, 0, newProtocolError(fmt.Sprintf("array too short, expected %d elements, got %d", arrLength, count))
 		}
-		switch data[i][0] {
-		case BULK_STRINGS:
-			str, n := parseBulkString(data[i:])
-			arr = append(arr, str)
-			consumed += n
-			i += n - 1 // - 1 because the loop will increment i by 1
-			count++
-		case SIMPLE_STRINGS:
-			str := parseSimpleString(data[i:])
-			arr = append(arr, str)
-			consumed++
-			count++
+		element, n, err := parseValue(data[consumed:], l, depth+1)
+		if err != nil {
+			return Value{}, 0, err
 		}
+		elements = append(elements, element)
+		consumed += n
 	}
 
-	return arr, consumed
+	return Value{Type: data[0], Array: elements}, consumed, nil
 }
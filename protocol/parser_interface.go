@@ -10,5 +10,6 @@ type Parser interface{
 	EncodeAsArray(data []string) string
 	NullBulkString() []byte 
 	Ok() []byte
-	Decode(data []byte) ([]string, error)
+	EncodeValue(v Value, version int) []byte
+	Decode(data []byte) ([]Value, error)
 }
@@ -1,7 +1,8 @@
 package protocol
 
 import (
-	"bytes"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -19,10 +20,9 @@ func TestParseSimpleString(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		splittedData := bytes.Split([]byte(c.input), END_LINE)
-		result := parseSimpleString(splittedData)
-		if result != c.expected {
-			t.Errorf("Expected %s but got %s", c.expected, result)
+		result, _, _ := parseSimpleString([]byte(c.input))
+		if result.Str != c.expected {
+			t.Errorf("Expected %s but got %s", c.expected, result.Str)
 		}
 	}
 }
@@ -37,10 +37,12 @@ func TestParseBulkString(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		splittedData := bytes.Split([]byte(c.input), END_LINE)
-		result, _ := parseBulkString(splittedData)
-		if result != c.expected {
-			t.Errorf("Expected %s but got %s", c.expected, result)
+		result, _, err := parseBulkString([]byte(c.input), DefaultLimits)
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if result.Str != c.expected {
+			t.Errorf("Expected %s but got %s", c.expected, result.Str)
 		}
 	}
 }
@@ -63,11 +65,13 @@ func TestParseArray(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		splittedData := bytes.Split([]byte(c.input), END_LINE)
-		result, _ := parseArray(splittedData)
-		for i, v := range result {
-			if v != c.expected[i] {
-				t.Errorf("Expected %s but got %s", c.expected[i], v)
+		result, _, err := parseArray([]byte(c.input), DefaultLimits, 0)
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		for i, v := range result.Array {
+			if v.Str != c.expected[i] {
+				t.Errorf("Expected %s but got %s", c.expected[i], v.Str)
 			}
 		}
 	}
@@ -90,7 +94,11 @@ func TestParseData(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		result := parseData([]byte(c.input))
+		values, err := parseData([]byte(c.input), DefaultLimits)
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		result := Flatten(values)
 
 		// check if the length of the result is the same as the expected
 		if len(result) != len(c.expected) {
@@ -105,6 +113,116 @@ func TestParseData(t *testing.T) {
 	}
 }
 
+func TestParseBinarySafeBulkString(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+	}{
+		{"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$8\r\nab\r\ncd\r\n\r\n", []string{"SET", "key", "ab\r\ncd\r\n"}},
+		{"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\n\x1f\x8b\x00\r\n\r\n", []string{"SET", "key", "\x1f\x8b\x00\r\n"}},
+		{"$4\r\n\r\n\r\n\r\n", []string{"\r\n\r\n"}},
+	}
+
+	for i, c := range cases {
+		values, err := parseData([]byte(c.input), DefaultLimits)
+		if err != nil {
+			t.Errorf("case [%d]: unexpected error: %s", i, err)
+			continue
+		}
+		result := Flatten(values)
+		if len(result) != len(c.expected) {
+			t.Errorf("case [%d]: expected %d elements, got %d", i, len(c.expected), len(result))
+			continue
+		}
+		for j, v := range result {
+			if v != c.expected[j] {
+				t.Errorf("case [%d]: expected %q but got %q", i, c.expected[j], v)
+			}
+		}
+	}
+}
+
+func TestDecodeValueTree(t *testing.T) {
+	input := "*2\r\n*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n:42\r\n$-1\r\n+OK\r\n-ERR boom\r\n*-1\r\n"
+	expected := []Value{
+		Array(Array(BulkString("SET"), BulkString("foo"), Integer(42)), NullBulkString()),
+		SimpleString("OK"),
+		Error("ERR boom"),
+		NullArray(),
+	}
+
+	parser := RedisProtocolParser{}
+	values, err := parser.Decode([]byte(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %+v, got %+v", expected, values)
+	}
+
+	// encoding the decoded values must return the original input
+	encoded := ""
+	for _, v := range values {
+		encoded += string(parser.EncodeValue(v, RESP2))
+	}
+	if encoded != input {
+		t.Errorf("expected %q, got %q", input, encoded)
+	}
+}
+
+func TestParseMalformedBulkString(t *testing.T) {
+	cases := []string{
+		"$5\r\nab",
+		"$abc\r\nab\r\n",
+		"*2\r\n$3\r\nGET\r\n$10\r\nfoo\r\n",
+		"$3\r\nfoobar\r\n",
+	}
+
+	for i, c := range cases {
+		_, err := parseData([]byte(c), DefaultLimits)
+		if err == nil {
+			t.Errorf("case [%d]: expected error for input %q", i, c)
+		} else if !IsProtocolError(err) {
+			t.Errorf("case [%d]: expected protocol error, got %v", i, err)
+		}
+	}
+}
+
+func TestDecodeNestingLimit(t *testing.T) {
+	// far more nested arrays than the stack could handle with recursion
+	deep := strings.Repeat("*1\r\n", 10_000_000) + ":1\r\n"
+	if _, err := (&RedisProtocolParser{}).Decode([]byte(deep)); !IsProtocolError(err) {
+		t.Errorf("expected a protocol error, got %v", err)
+	}
+
+	parser := &RedisProtocolParser{Limits: Limits{MaxDepth: 2}}
+	if _, err := parser.Decode([]byte("*1\r\n*1\r\n:1\r\n")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := parser.Decode([]byte("*1\r\n*1\r\n*1\r\n:1\r\n")); !IsProtocolError(err) {
+		t.Errorf("expected a protocol error, got %v", err)
+	}
+}
+
+func TestDecodeLengthLimits(t *testing.T) {
+	parser := &RedisProtocolParser{Limits: Limits{MaxBulkLen: 3, MaxMultibulkLen: 2}}
+	for input, ok := range map[string]bool{
+		"*2\r\n$3\r\nfoo\r\n$3\r\nbar\r\n": true,
+		"*1\r\n$4\r\nfoob\r\n":             false,
+		"*3\r\n:1\r\n:2\r\n:3\r\n":         false,
+		"%2\r\n:1\r\n:2\r\n:3\r\n:4\r\n":   false,
+	} {
+		_, err := parser.Decode([]byte(input))
+		if ok && err != nil {
+			t.Errorf("%q: unexpected error: %v", input, err)
+		}
+		if !ok && !IsProtocolError(err) {
+			t.Errorf("%q: expected a protocol error, got %v", input, err)
+		}
+	}
+}
+
 func TestEncodeData(t *testing.T) {
 	cases := []struct {
 		input    []string
@@ -131,13 +249,13 @@ func TestEncodeData(t *testing.T) {
 	}
 }
 
-func TestEncodeMapToArray(t *testing.T){
+func TestEncodeMapToArray(t *testing.T) {
 	input := map[string]string{
 		"temperature": "36",
-		"humidity": "95",
+		"humidity":    "95",
 	}
 
-	expect := "*4\r\n$11\r\ntemperature\r\n$2\r\n36\r\n$8\r\nhumidity\r\n$2\r\n95\r\n"
+	expect := "*4\r\n$8\r\nhumidity\r\n$2\r\n95\r\n$11\r\ntemperature\r\n$2\r\n36\r\n"
 
 	parser := RedisProtocolParser{}
 
@@ -147,3 +265,43 @@ func TestEncodeMapToArray(t *testing.T){
 		t.Errorf("error parsing map, got\n%s, expect\n%s", res, expect)
 	}
 }
+
+func TestEncodeValueResp3(t *testing.T) {
+	cases := []struct {
+		input Value
+		resp2 string
+		resp3 string
+	}{
+		{Map(BulkString("a"), Integer(1)), "*2\r\n$1\r\na\r\n:1\r\n", "%1\r\n$1\r\na\r\n:1\r\n"},
+		{Set(BulkString("x")), "*1\r\n$1\r\nx\r\n", "~1\r\n$1\r\nx\r\n"},
+		{Push(BulkString("message")), "*1\r\n$7\r\nmessage\r\n", ">1\r\n$7\r\nmessage\r\n"},
+		{Double(1.5), "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{Boolean(true), ":1\r\n", "#t\r\n"},
+		{NullBulkString(), "$-1\r\n", "_\r\n"},
+		{NullArray(), "*-1\r\n", "_\r\n"},
+		{BigNumber("3492890328409238509324850943850943825024385"), "$43\r\n3492890328409238509324850943850943825024385\r\n", "(3492890328409238509324850943850943825024385\r\n"},
+		{VerbatimString("txt", "Some string"), "$11\r\nSome string\r\n", "=15\r\ntxt:Some string\r\n"},
+	}
+
+	parser := RedisProtocolParser{}
+
+	for i, c := range cases {
+		if resp := string(parser.EncodeValue(c.input, RESP2)); resp != c.resp2 {
+			t.Errorf("case [%d]: expected RESP2 %q, got %q", i, c.resp2, resp)
+		}
+		resp3 := parser.EncodeValue(c.input, RESP3)
+		if string(resp3) != c.resp3 {
+			t.Errorf("case [%d]: expected RESP3 %q, got %q", i, c.resp3, resp3)
+		}
+
+		// RESP3 output must decode back to the same value
+		decoded, err := parser.Decode(resp3)
+		if err != nil {
+			t.Errorf("case [%d]: unexpected error decoding %q: %s", i, resp3, err)
+			continue
+		}
+		if len(decoded) != 1 || string(parser.EncodeValue(decoded[0], RESP3)) != c.resp3 {
+			t.Errorf("case [%d]: round trip mismatch for %q, got %+v", i, resp3, decoded)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+)
+
+// ErrIncompleteFrame is returned by FrameLength when the data does not contain
+// a complete top-level frame yet and more bytes have to be read
+var ErrIncompleteFrame = errors.New("incomplete frame")
+
+const defaultReaderSize = 4096
+
+// Reader reads complete RESP frames from an io.Reader. Bytes that belong to
+// the next frame are kept in the internal buffer for the next call, so frames
+// split across several reads or several frames coalesced in a single read are
+// both handled.
+type Reader struct {
+	Limits Limits
+
+	rd   io.Reader
+	buf  []byte
+	r    int // read position
+	w    int // write position
+	scan frameScanner
+}
+
+func NewReader(rd io.Reader) *Reader {
+	return &Reader{
+		Limits: DefaultLimits,
+		rd:     rd,
+		buf:    make([]byte, defaultReaderSize),
+	}
+}
+
+// ReadFrame returns the next complete top-level frame. The returned slice is
+// only valid until the next call to ReadFrame. The elements of a frame are
+// scanned once, a frame received in many reads isn't scanned again from the
+// start after each one.
+func (r *Reader) ReadFrame() ([]byte, error) {
+	for {
+		n, err := r.scan.next(r.Limits, r.buf[r.r:r.w])
+		if err == nil {
+			frame := r.buf[r.r : r.r+n]
+			r.r += n
+			return frame, nil
+		}
+		if err != ErrIncompleteFrame {
+			r.scan = frameScanner{}
+			return nil, err
+		}
+		if err := r.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ReadRawBulk reads a bulk string that is not followed by CRLF, like the RDB
+// file sent by the master after FULLRESYNC ("$<len>\r\n<payload>")
+func (r *Reader) ReadRawBulk() ([]byte, error) {
+	limits := r.Limits.withDefaults()
+	for {
+		line, next, err := limits.readLine(r.buf[r.r:r.w], 0)
+		if err == nil {
+			if len(line) == 0 || line[0] != BULK_STRINGS {
+				return nil, newProtocolError("expected bulk string")
+			}
+			length, err := strconv.Atoi(string(line[1:]))
+			if err != nil || length < 0 || length > limits.MaxBulkLen {
+				return nil, newProtocolError("invalid bulk length")
+			}
+			if length <= r.Buffered()-next {
+				payload := make([]byte, length)
+				copy(payload, r.buf[r.r+next:r.r+next+length])
+				r.r += next + length
+				return payload, nil
+			}
+		} else if err != ErrIncompleteFrame {
+			return nil, err
+		}
+		if err := r.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Buffered returns the number of bytes already read from the underlying
+// reader that have not been returned as a frame yet
+func (r *Reader) Buffered() int {
+	return r.w - r.r
+}
+
+// fill reads more data into the buffer, moving the unread bytes to the
+// beginning of the buffer and growing it when it is full
+func (r *Reader) fill() error {
+	if r.r > 0 {
+		copy(r.buf, r.buf[r.r:r.w])
+		r.w -= r.r
+		r.r = 0
+	}
+
+	if r.w == len(r.buf) {
+		newBuf := make([]byte, len(r.buf)*2)
+		copy(newBuf, r.buf[:r.w])
+		r.buf = newBuf
+	}
+
+	n, err := r.rd.Read(r.buf[r.w:])
+	r.w += n
+	if n > 0 {
+		return nil
+	}
+	if err == nil {
+		return io.ErrNoProgress
+	}
+	if err == io.EOF && r.w > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// FrameLength returns the number of bytes of the first complete frame in data
+// using the default limits.
+func FrameLength(data []byte) (int, error) {
+	return DefaultLimits.FrameLength(data)
+}
+
+// FrameLength returns the number of bytes of the first complete frame in data.
+// If data only contains part of a frame, ErrIncompleteFrame is returned.
+// A frame that doesn't start with a RESP type byte is an inline command and
+// ends at the first new line.
+// Lengths over the limits are rejected as soon as their header is complete,
+// without waiting for the rest of the frame.
+func (l Limits) FrameLength(data []byte) (int, error) {
+	var s frameScanner
+	return s.next(l, data)
+}
+
+// frameScanner finds the end of a frame one element at a time. When the frame
+// is incomplete it keeps the position of the first element that couldn't be
+// scanned, so the next call with more data continues from there.
+type frameScanner struct {
+	pos int
+	// elements left in each aggregate that isn't complete, the innermost one
+	// is the last
+	pending []int
+}
+
+// next returns the length of the first frame in data, which must start with
+// the data passed to the previous calls until a frame is returned
+func (s *frameScanner) next(l Limits, data []byte) (int, error) {
+	l = l.withDefaults()
+	if s.pos == 0 && len(data) > 0 && !isRespType(data[0]) {
+		n, err := inlineLength(data)
+		if (err == nil && n > l.MaxInlineLen) || (err == ErrIncompleteFrame && len(data) > l.MaxInlineLen) {
+			return 0, newProtocolError("too big inline request")
+		}
+		return n, err
+	}
+
+	for {
+		next, count, err := l.element(data, s.pos, len(s.pending))
+		if err != nil {
+			return 0, err
+		}
+		s.pos = next
+		if count > 0 {
+			s.pending = append(s.pending, count)
+			continue
+		}
+		// the element completes the aggregates it was the last element of
+		for len(s.pending) > 0 {
+			s.pending[len(s.pending)-1]--
+			if s.pending[len(s.pending)-1] > 0 {
+				break
+			}
+			s.pending = s.pending[:len(s.pending)-1]
+		}
+		if len(s.pending) == 0 {
+			s.pos = 0
+			return next, nil
+		}
+	}
+}
+
+// element scans the element at pos, it returns the position right after it.
+// For an aggregate only the header is scanned and count is the number of
+// elements that follow it.
+func (l Limits) element(data []byte, pos int, depth int) (next int, count int, err error) {
+	if pos >= len(data) {
+		return 0, 0, ErrIncompleteFrame
+	}
+
+	line, next, err := l.readLine(data, pos)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	switch data[pos] {
+	case SIMPLE_STRINGS, SIMPLE_ERRORS, INTEGERS, NULLS, BOOLEANS, DOUBLES, BIG_NUMBERS:
+		return next, 0, nil
+
+	case BULK_STRINGS, BULK_ERRORS, VERBATIM_STRINGS:
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil || length < -1 || length > l.MaxBulkLen {
+			return 0, 0, newProtocolError("invalid bulk length")
+		}
+		if length == -1 {
+			return next, 0, nil
+		}
+		// the payload isn't scanned, only its end is checked
+		if length > len(data)-next-len(END_LINE) {
+			return 0, 0, ErrIncompleteFrame
+		}
+		end := next + length + len(END_LINE)
+		if !bytes.Equal(data[end-len(END_LINE):end], END_LINE) {
+			return 0, 0, newProtocolError("expected CRLF after bulk string")
+		}
+		return end, 0, nil
+
+	case ARRAY, SETS, PUSHES, MAPS:
+		count, err := strconv.Atoi(string(line[1:]))
+		if err != nil || count < -1 || count > math.MaxInt32 {
+			return 0, 0, newProtocolError("invalid multibulk length")
+		}
+		if data[pos] == MAPS {
+			count *= 2
+		}
+		if count > l.MaxMultibulkLen {
+			return 0, 0, newProtocolError("invalid multibulk length")
+		}
+		if depth >= l.MaxDepth {
+			return 0, 0, newProtocolError("too many nested aggregates")
+		}
+		return next, count, nil
+
+	default:
+		return 0, 0, newProtocolError("unexpected type byte '" + string(data[pos]) + "'")
+	}
+}
+
+// readLine returns the line starting at pos without the CRLF terminator and
+// the position right after it
+func (l Limits) readLine(data []byte, pos int) (line []byte, next int, err error) {
+	i := bytes.Index(data[pos:], END_LINE)
+	if i < 0 {
+		if len(data)-pos > l.MaxInlineLen {
+			return nil, 0, newProtocolError("too big line")
+		}
+		return nil, 0, ErrIncompleteFrame
+	}
+	if i > l.MaxInlineLen {
+		return nil, 0, newProtocolError("too big line")
+	}
+	return data[pos : pos+i], pos + i + len(END_LINE), nil
+}
@@ -0,0 +1,171 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFrameLength(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected int
+		err      error
+	}{
+		{"+OK\r\n", 5, nil},
+		{"+OK\r\n+PONG\r\n", 5, nil},
+		{"+OK", 0, ErrIncompleteFrame},
+		{"$5\r\nhello\r\n", 11, nil},
+		{"$5\r\nhel", 0, ErrIncompleteFrame},
+		{"$5\r\nhello", 0, ErrIncompleteFrame},
+		{"$-1\r\n", 5, nil},
+		{"*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n*1\r\n", 22, nil},
+		{"*2\r\n$3\r\nGET\r\n", 0, ErrIncompleteFrame},
+		{"*0\r\n", 4, nil},
+		{"", 0, ErrIncompleteFrame},
+	}
+
+	for i, c := range cases {
+		n, err := FrameLength([]byte(c.input))
+		if err != c.err {
+			t.Errorf("case [%d]: expected error %v, got %v", i, c.err, err)
+		}
+		if n != c.expected {
+			t.Errorf("case [%d]: expected length %d, got %d", i, c.expected, n)
+		}
+	}
+}
+
+func TestReadFramePartialReads(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n*1\r\n$4\r\nPING\r\n"
+	reader := NewReader(iotest.OneByteReader(strings.NewReader(input)))
+
+	expected := []string{
+		"*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n",
+		"*1\r\n$4\r\nPING\r\n",
+	}
+
+	for i, e := range expected {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("frame [%d]: unexpected error: %v", i, err)
+		}
+		if string(frame) != e {
+			t.Errorf("frame [%d]: expected %q, got %q", i, e, frame)
+		}
+	}
+
+	if _, err := reader.ReadFrame(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestFrameScannerResumes(t *testing.T) {
+	input := "*2\r\n%1\r\n+a\r\n*1\r\n:1\r\n$3\r\nfoo\r\n+OK\r\n"
+	end := len(input) - len("+OK\r\n")
+	var s frameScanner
+	for i := range end {
+		n, err := s.next(DefaultLimits, []byte(input[:i]))
+		if err != ErrIncompleteFrame {
+			t.Fatalf("prefix [%d]: expected ErrIncompleteFrame, got %d, %v", i, n, err)
+		}
+		// the scan stops at the start of the incomplete element
+		if !strings.HasSuffix(input[:s.pos], "\r\n") && s.pos != 0 {
+			t.Fatalf("prefix [%d]: the scan stopped in the middle of an element at %d", i, s.pos)
+		}
+		if i == 20 && (s.pos != 20 || len(s.pending) != 1 || s.pending[0] != 1) {
+			t.Errorf("expected the scan to stop at the bulk string with one element pending, got %d and %v", s.pos, s.pending)
+		}
+	}
+
+	n, err := s.next(DefaultLimits, []byte(input))
+	if err != nil || n != end {
+		t.Errorf("expected a frame of %d bytes, got %d, %v", end, n, err)
+	}
+	if s.pos != 0 || len(s.pending) != 0 {
+		t.Errorf("expected the scanner to be reset after a frame")
+	}
+}
+
+func TestReadFrameLargeBulkString(t *testing.T) {
+	payload := strings.Repeat("x", 300*1024)
+	parser := RedisProtocolParser{}
+	input := parser.EncodeAsArray([]string{"SET", "blob", payload})
+
+	reader := NewReader(bytes.NewReader([]byte(input)))
+	frame, err := reader.ReadFrame()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(frame) != input {
+		t.Errorf("frame length mismatch, expected %d bytes, got %d", len(input), len(frame))
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	reader := NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$3\r\nfo"))
+	if _, err := reader.ReadFrame(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestReadFrameLimits(t *testing.T) {
+	limits := Limits{MaxBulkLen: 10, MaxMultibulkLen: 3, MaxInlineLen: 16, MaxDepth: 2}
+	cases := []struct {
+		input string
+		err   string
+	}{
+		{"$11\r\n", "Protocol error: invalid bulk length"},
+		{"*2\r\n$3\r\nGET\r\n$999999999\r\n", "Protocol error: invalid bulk length"},
+		{"*4\r\n", "Protocol error: invalid multibulk length"},
+		{"*2147483647\r\n", "Protocol error: invalid multibulk length"},
+		{"%2\r\n", "Protocol error: invalid multibulk length"},
+		{"*1\r\n*1\r\n*1\r\n:1\r\n", "Protocol error: too many nested aggregates"},
+		{"SET key a-very-long-value\r\n", "Protocol error: too big inline request"},
+		{"$11111111111111111111", "Protocol error: too big line"},
+	}
+
+	for i, c := range cases {
+		// the payload is never sent, the limits must be checked with the header only
+		conn := iotest.OneByteReader(strings.NewReader(c.input))
+		reader := NewReader(conn)
+		reader.Limits = limits
+		_, err := reader.ReadFrame()
+		if err == nil || err.Error() != c.err {
+			t.Errorf("case [%d]: expected error %q, got %v", i, c.err, err)
+		}
+	}
+
+	reader := NewReader(strings.NewReader("*1\r\n*3\r\n$10\r\n0123456789\r\n:1\r\n_\r\n"))
+	reader.Limits = limits
+	if _, err := reader.ReadFrame(); err != nil {
+		t.Errorf("unexpected error for a frame within the limits: %v", err)
+	}
+}
+
+func TestLimitsDefaults(t *testing.T) {
+	reader := NewReader(strings.NewReader("$536870913\r\n"))
+	reader.Limits = Limits{}
+	if _, err := reader.ReadFrame(); err == nil || !IsProtocolError(err) {
+		t.Errorf("expected protocol error for a bulk over the default limit, got %v", err)
+	}
+}
+
+// a frame with many elements received in small reads, every element is
+// scanned once
+func BenchmarkReadFrameSmallReads(b *testing.B) {
+	args := make([]string, 10000)
+	for i := range args {
+		args[i] = "element"
+	}
+	input := []byte((&RedisProtocolParser{}).EncodeAsArray(args))
+	b.ReportAllocs()
+	for b.Loop() {
+		reader := NewReader(iotest.OneByteReader(bytes.NewReader(input)))
+		if _, err := reader.ReadFrame(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,16 +1,23 @@
 package protocol
 
 import (
+	"bytes"
+	"sort"
 	"strconv"
+	"strings"
 )
 
-type RedisProtocolParser struct{}
+type RedisProtocolParser struct {
+	// Limits bounds the values accepted by Decode, zero fields use the
+	// defaults
+	Limits Limits
+}
 
-func (r *RedisProtocolParser) Encode(data []string) (string, error){
-	if len(data) > 0 || data[0] == REPLCONF || data[0] == PING{
+func (r *RedisProtocolParser) Encode(data []string) (string, error) {
+	if len(data) > 0 || data[0] == REPLCONF || data[0] == PING {
 		return r.EncodeAsArray(data), nil
 	} else {
-		return "+" + data[0] + ENDL , nil
+		return "+" + data[0] + ENDL, nil
 	}
 }
 
@@ -37,44 +44,77 @@ func (r *RedisProtocolParser) EncodeError(msg string) []byte {
 }
 
 func (r *RedisProtocolParser) EncodeAsArray(data []string) string {
-	if len(data) == 0 {
-		return "*0\r\n"
+	size := 16
+	for _, arg := range data {
+		size += len(arg) + 16
 	}
-	content := "*" + strconv.Itoa(len(data)) + "\r\n" + "$" + strconv.Itoa(len(data[0])) + "\r\n" + data[0] + "\r\n"
-	for _, arg := range data[1:]{
-		content += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	var b strings.Builder
+	b.Grow(size)
+	writeHeader(&b, ARRAY, len(data))
+	for _, arg := range data {
+		writeHeader(&b, BULK_STRINGS, len(arg))
+		b.WriteString(arg)
+		b.WriteString(ENDL)
 	}
-
-	return content
+	return b.String()
 }
 
 func (r *RedisProtocolParser) ConcatenateArray(data []string) string {
-	content := "*"+strconv.Itoa(len(data)) + "\r\n"
+	size := 16
 	for _, arr := range data {
-		content += arr
+		size += len(arr)
 	}
-	return content
+	var b strings.Builder
+	b.Grow(size)
+	writeHeader(&b, ARRAY, len(data))
+	for _, arr := range data {
+		b.WriteString(arr)
+	}
+	return b.String()
 }
+
+// MapToArray returns the map as a flat array of keys and values, sorted by key
 func (r *RedisProtocolParser) MapToArray(data map[string]string) string {
-	content := "*"+strconv.Itoa(len(data)*2) + "\r\n"
-	for key, value := range data{
-		s1 := r.EncodeBulkString(key, true)
-		s2 := r.EncodeBulkString(value, true)
-		content  = content + s1 + s2
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
 	}
-	return content 
+	sort.Strings(keys)
+
+	flat := make([]string, 0, len(data)*2)
+	for _, key := range keys {
+		flat = append(flat, key, data[key])
+	}
+	return r.EncodeAsArray(flat)
+}
+
+func writeHeader(b *strings.Builder, typ byte, n int) {
+	var scratch [24]byte
+	buf := append(scratch[:0], typ)
+	buf = strconv.AppendInt(buf, int64(n), 10)
+	b.Write(buf)
+	b.WriteString(ENDL)
 }
 
 func (r *RedisProtocolParser) NullBulkString() []byte {
- return []byte{36, 45, 49, 13, 10} // $-1\r\n
+	return []byte{36, 45, 49, 13, 10} // $-1\r\n
 }
 
 func (r *RedisProtocolParser) Ok() []byte {
- return []byte{43, 79, 75, 13, 10} // +OK\r\n
+	return []byte{43, 79, 75, 13, 10} // +OK\r\n
 }
 
-func (r *RedisProtocolParser) Decode(data []byte) ([]string, error){
-	return parseData(data), nil
+// EncodeValue returns the representation of v for the given protocol version (RESP2 or RESP3)
+func (r *RedisProtocolParser) EncodeValue(v Value, version int) []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Protocol = version
+	w.WriteValue(v)
+	w.Flush()
+	return buf.Bytes()
 }
 
-
+// Decode returns the top-level values contained in data
+func (r *RedisProtocolParser) Decode(data []byte) ([]Value, error) {
+	return parseData(data, r.Limits)
+}
@@ -0,0 +1,183 @@
+package protocol
+
+import (
+	"strconv"
+	"strings"
+)
+
+// protocol versions negotiated with HELLO
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// Value is a decoded RESP value. Type holds the RESP type byte (SIMPLE_STRINGS,
+// BULK_STRINGS, ARRAY...), which decides the meaning of the other fields:
+//
+//	simple strings, bulk strings, errors, big numbers -> Str
+//	verbatim strings                                 -> Str ("txt:content")
+//	integers                                         -> Int
+//	doubles                                          -> Float
+//	booleans                                         -> Bool
+//	arrays, sets and pushes                          -> Array
+//	maps                                             -> Array (key, value, key, value...)
+//
+// Null bulk strings, null arrays and RESP3 nulls have Null set to true.
+type Value struct {
+	Type  byte
+	Str   string
+	Int   int64
+	Float float64
+	Bool  bool
+	Array []Value
+	Null  bool
+}
+
+func SimpleString(s string) Value {
+	return Value{Type: SIMPLE_STRINGS, Str: s}
+}
+
+func BulkString(s string) Value {
+	return Value{Type: BULK_STRINGS, Str: s}
+}
+
+func Integer(n int64) Value {
+	return Value{Type: INTEGERS, Int: n}
+}
+
+func Error(msg string) Value {
+	return Value{Type: SIMPLE_ERRORS, Str: msg}
+}
+
+func Double(f float64) Value {
+	return Value{Type: DOUBLES, Float: f}
+}
+
+func Boolean(b bool) Value {
+	return Value{Type: BOOLEANS, Bool: b}
+}
+
+func BigNumber(n string) Value {
+	return Value{Type: BIG_NUMBERS, Str: n}
+}
+
+// VerbatimString returns a verbatim string, format is a three letters type like "txt" or "mkd"
+func VerbatimString(format, s string) Value {
+	return Value{Type: VERBATIM_STRINGS, Str: format + ":" + s}
+}
+
+func Array(values ...Value) Value {
+	if values == nil {
+		values = []Value{}
+	}
+	return Value{Type: ARRAY, Array: values}
+}
+
+func Set(values ...Value) Value {
+	v := Array(values...)
+	v.Type = SETS
+	return v
+}
+
+func Push(values ...Value) Value {
+	v := Array(values...)
+	v.Type = PUSHES
+	return v
+}
+
+// Map returns a map, pairs contains the keys and values interleaved
+func Map(pairs ...Value) Value {
+	v := Array(pairs...)
+	v.Type = MAPS
+	return v
+}
+
+// StringMap returns a map of bulk strings, keys holds the order of the entries
+func StringMap(keys []string, data map[string]string) Value {
+	pairs := make([]Value, 0, len(keys)*2)
+	for _, k := range keys {
+		pairs = append(pairs, BulkString(k), BulkString(data[k]))
+	}
+	return Map(pairs...)
+}
+
+// BulkStringArray returns an array of bulk strings
+func BulkStringArray(data []string) Value {
+	values := make([]Value, len(data))
+	for i, s := range data {
+		values[i] = BulkString(s)
+	}
+	return Array(values...)
+}
+
+func NullBulkString() Value {
+	return Value{Type: BULK_STRINGS, Null: true}
+}
+
+func NullArray() Value {
+	return Value{Type: ARRAY, Null: true}
+}
+
+func Null() Value {
+	return Value{Type: NULLS, Null: true}
+}
+
+func (v Value) IsError() bool {
+	return v.Type == SIMPLE_ERRORS || v.Type == BULK_ERRORS
+}
+
+// Flatten returns the string content of the values, the elements of nested
+// arrays are added in order and null values are skipped
+func Flatten(values []Value) []string {
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if v.Null {
+			continue
+		}
+		switch v.Type {
+		case ARRAY, SETS, PUSHES, MAPS:
+			result = append(result, Flatten(v.Array)...)
+		case INTEGERS:
+			result = append(result, strconv.FormatInt(v.Int, 10))
+		case DOUBLES:
+			result = append(result, formatDouble(v.Float))
+		case BOOLEANS:
+			result = append(result, strconv.FormatBool(v.Bool))
+		default:
+			result = append(result, v.Str)
+		}
+	}
+	return result
+}
+
+func formatDouble(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	switch s {
+	case "+Inf":
+		return "inf"
+	case "-Inf":
+		return "-inf"
+	case "NaN":
+		return "nan"
+	}
+	return s
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func parseDouble(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "inf", "+inf":
+		s = "+Inf"
+	case "-inf":
+		s = "-Inf"
+	case "nan":
+		s = "NaN"
+	}
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,249 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Writer writes RESP replies into a buffer, nothing is sent until Flush is
+// called, so the replies of a batch of pipelined commands can be sent with a
+// single write. The buffer grows as needed, a command never waits on a slow
+// client while it writes its reply. Protocol holds the version negotiated
+// with HELLO, the RESP3 types are written as their RESP2 equivalent for RESP2
+// clients.
+type Writer struct {
+	w        bytes.Buffer
+	out      io.Writer
+	Protocol int
+	scratch  [32]byte
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		out:      w,
+		Protocol: RESP2,
+	}
+}
+
+// Flush sends the buffered replies
+func (w *Writer) Flush() error {
+	if w.w.Len() == 0 {
+		return nil
+	}
+	_, err := w.out.Write(w.w.Bytes())
+	w.w.Reset()
+	return err
+}
+
+// Buffered returns the number of bytes waiting to be flushed
+func (w *Writer) Buffered() int {
+	return w.w.Len()
+}
+
+func (w *Writer) WriteSimpleString(s string) error {
+	return w.writeLine(SIMPLE_STRINGS, s)
+}
+
+// WriteError writes a simple error, msg must start with the error code, e.g. "ERR syntax error"
+func (w *Writer) WriteError(msg string) error {
+	return w.writeLine(SIMPLE_ERRORS, msg)
+}
+
+func (w *Writer) WriteOK() error {
+	_, err := w.w.WriteString("+OK\r\n")
+	return err
+}
+
+func (w *Writer) WriteInt(n int64) error {
+	return w.writeNumber(INTEGERS, n)
+}
+
+func (w *Writer) WriteBulk(s string) error {
+	if err := w.writeNumber(BULK_STRINGS, int64(len(s))); err != nil {
+		return err
+	}
+	w.w.WriteString(s)
+	_, err := w.w.Write(END_LINE)
+	return err
+}
+
+// WriteBulkArray writes an array of bulk strings
+func (w *Writer) WriteBulkArray(data []string) error {
+	w.WriteArrayHeader(len(data))
+	for _, s := range data {
+		w.WriteBulk(s)
+	}
+	return nil
+}
+
+// WriteNull writes a null, "$-1" for RESP2 clients
+func (w *Writer) WriteNull() error {
+	if w.Protocol == RESP3 {
+		return w.writeRaw("_\r\n")
+	}
+	return w.writeRaw("$-1\r\n")
+}
+
+// WriteNullArray writes a null, "*-1" for RESP2 clients
+func (w *Writer) WriteNullArray() error {
+	if w.Protocol == RESP3 {
+		return w.writeRaw("_\r\n")
+	}
+	return w.writeRaw("*-1\r\n")
+}
+
+func (w *Writer) WriteArrayHeader(n int) error {
+	return w.writeNumber(ARRAY, int64(n))
+}
+
+// WriteMapHeader starts a map of n entries, RESP2 clients receive an array of 2*n elements
+func (w *Writer) WriteMapHeader(n int) error {
+	if w.Protocol == RESP3 {
+		return w.writeNumber(MAPS, int64(n))
+	}
+	return w.writeNumber(ARRAY, int64(n*2))
+}
+
+// WriteSetHeader starts a set of n elements, RESP2 clients receive an array
+func (w *Writer) WriteSetHeader(n int) error {
+	if w.Protocol == RESP3 {
+		return w.writeNumber(SETS, int64(n))
+	}
+	return w.writeNumber(ARRAY, int64(n))
+}
+
+// WritePushHeader starts an out of band push message, RESP2 clients receive an array
+func (w *Writer) WritePushHeader(n int) error {
+	if w.Protocol == RESP3 {
+		return w.writeNumber(PUSHES, int64(n))
+	}
+	return w.writeNumber(ARRAY, int64(n))
+}
+
+// WriteDouble writes a double, RESP2 clients receive a bulk string
+func (w *Writer) WriteDouble(f float64) error {
+	if w.Protocol == RESP3 {
+		return w.writeLine(DOUBLES, formatDouble(f))
+	}
+	return w.WriteBulk(formatDouble(f))
+}
+
+// WriteBool writes a boolean, RESP2 clients receive 1 or 0
+func (w *Writer) WriteBool(b bool) error {
+	if w.Protocol == RESP3 {
+		if b {
+			return w.writeRaw("#t\r\n")
+		}
+		return w.writeRaw("#f\r\n")
+	}
+	return w.WriteInt(boolToInt(b))
+}
+
+// WriteVerbatim writes a verbatim string like "txt:content", RESP2 clients receive a bulk string
+func (w *Writer) WriteVerbatim(format, s string) error {
+	if w.Protocol != RESP3 {
+		return w.WriteBulk(s)
+	}
+	if err := w.writeNumber(VERBATIM_STRINGS, int64(len(format)+1+len(s))); err != nil {
+		return err
+	}
+	w.w.WriteString(format)
+	w.w.WriteByte(':')
+	w.w.WriteString(s)
+	_, err := w.w.Write(END_LINE)
+	return err
+}
+
+// WriteValue writes a value tree
+func (w *Writer) WriteValue(v Value) error {
+	if v.Null {
+		if v.Type == ARRAY || v.Type == SETS || v.Type == MAPS || v.Type == PUSHES {
+			return w.WriteNullArray()
+		}
+		return w.WriteNull()
+	}
+
+	switch v.Type {
+	case SIMPLE_STRINGS:
+		return w.WriteSimpleString(v.Str)
+	case SIMPLE_ERRORS:
+		return w.WriteError(v.Str)
+	case BULK_ERRORS:
+		if w.Protocol != RESP3 {
+			return w.WriteError(v.Str)
+		}
+		w.writeNumber(BULK_ERRORS, int64(len(v.Str)))
+		w.w.WriteString(v.Str)
+		_, err := w.w.Write(END_LINE)
+		return err
+	case INTEGERS:
+		return w.WriteInt(v.Int)
+	case BULK_STRINGS:
+		return w.WriteBulk(v.Str)
+	case DOUBLES:
+		return w.WriteDouble(v.Float)
+	case BOOLEANS:
+		return w.WriteBool(v.Bool)
+	case BIG_NUMBERS:
+		if w.Protocol != RESP3 {
+			return w.WriteBulk(v.Str)
+		}
+		return w.writeLine(BIG_NUMBERS, v.Str)
+	case VERBATIM_STRINGS:
+		if len(v.Str) >= 4 && v.Str[3] == ':' {
+			return w.WriteVerbatim(v.Str[:3], v.Str[4:])
+		}
+		return w.WriteVerbatim("txt", v.Str)
+	case NULLS:
+		return w.WriteNull()
+	case ARRAY:
+		w.WriteArrayHeader(len(v.Array))
+	case SETS:
+		w.WriteSetHeader(len(v.Array))
+	case PUSHES:
+		w.WritePushHeader(len(v.Array))
+	case MAPS:
+		w.WriteMapHeader(len(v.Array) / 2)
+	}
+
+	for _, e := range v.Array {
+		if err := w.WriteValue(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) writeRaw(s string) error {
+	_, err := w.w.WriteString(s)
+	return err
+}
+
+// writeLine writes a reply that ends at the first CRLF, CR and LF in s are
+// replaced with spaces like redis does so s can't inject other replies
+func (w *Writer) writeLine(typ byte, s string) error {
+	w.w.WriteByte(typ)
+	if strings.ContainsAny(s, "\r\n") {
+		for i := 0; i < len(s); i++ {
+			if s[i] == '\r' || s[i] == '\n' {
+				w.w.WriteByte(' ')
+			} else {
+				w.w.WriteByte(s[i])
+			}
+		}
+	} else {
+		w.w.WriteString(s)
+	}
+	_, err := w.w.Write(END_LINE)
+	return err
+}
+
+func (w *Writer) writeNumber(typ byte, n int64) error {
+	buf := append(w.scratch[:0], typ)
+	buf = strconv.AppendInt(buf, n, 10)
+	buf = append(buf, END_LINE...)
+	_, err := w.w.Write(buf)
+	return err
+}
@@ -0,0 +1,152 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	cases := []struct {
+		write func(w *Writer)
+		resp2 string
+		resp3 string
+	}{
+		{func(w *Writer) { w.WriteOK() }, "+OK\r\n", "+OK\r\n"},
+		{func(w *Writer) { w.WriteError("ERR boom") }, "-ERR boom\r\n", "-ERR boom\r\n"},
+		{func(w *Writer) { w.WriteInt(-42) }, ":-42\r\n", ":-42\r\n"},
+		{func(w *Writer) { w.WriteBulk("a\r\nb") }, "$4\r\na\r\nb\r\n", "$4\r\na\r\nb\r\n"},
+		{func(w *Writer) { w.WriteBulkArray([]string{"a", ""}) }, "*2\r\n$1\r\na\r\n$0\r\n\r\n", "*2\r\n$1\r\na\r\n$0\r\n\r\n"},
+		{func(w *Writer) { w.WriteNull() }, "$-1\r\n", "_\r\n"},
+		{func(w *Writer) { w.WriteNullArray() }, "*-1\r\n", "_\r\n"},
+		{func(w *Writer) { w.WriteMapHeader(2) }, "*4\r\n", "%2\r\n"},
+		{func(w *Writer) { w.WriteDouble(0.5) }, "$3\r\n0.5\r\n", ",0.5\r\n"},
+		{func(w *Writer) { w.WriteBool(false) }, ":0\r\n", "#f\r\n"},
+		{func(w *Writer) { w.WriteVerbatim("txt", "hi") }, "$2\r\nhi\r\n", "=6\r\ntxt:hi\r\n"},
+	}
+
+	for i, c := range cases {
+		for _, version := range []int{RESP2, RESP3} {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			w.Protocol = version
+			c.write(w)
+
+			if buf.Len() != 0 {
+				t.Errorf("case [%d]: data written before Flush", i)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("case [%d]: unexpected error: %s", i, err)
+			}
+
+			expected := c.resp2
+			if version == RESP3 {
+				expected = c.resp3
+			}
+			if buf.String() != expected {
+				t.Errorf("case [%d] RESP%d: expected %q, got %q", i, version, expected, buf.String())
+			}
+		}
+	}
+}
+
+func TestWriterLineInjection(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteError("ERR unknown command 'foo\r\n:42\r\nxy'")
+	w.WriteSimpleString("a\r\n:7")
+	w.Flush()
+
+	expected := "-ERR unknown command 'foo  :42  xy'\r\n+a  :7\r\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+// a reply larger than any internal buffer is still sent only by Flush
+func TestWriterLargeReply(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	values := lrangeReply(10000)
+	w.WriteBulkArray(values)
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing to be written before Flush, got %d bytes", buf.Len())
+	}
+	if w.Buffered() != len(concatenateArray(values)) {
+		t.Errorf("expected the whole reply to be buffered, got %d bytes", w.Buffered())
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if buf.String() != concatenateArray(values) || w.Buffered() != 0 {
+		t.Errorf("expected the reply to be sent by Flush")
+	}
+}
+
+func lrangeReply(n int) []string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = "element-" + strconv.Itoa(i)
+	}
+	return values
+}
+
+// concatenateArray is the string concatenation encoder the Writer replaced,
+// kept as the baseline of the benchmarks
+func concatenateArray(data []string) string {
+	content := "*" + strconv.Itoa(len(data)) + "\r\n"
+	for _, arg := range data {
+		content += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	return content
+}
+
+func BenchmarkLRange10kConcatenation(b *testing.B) {
+	values := lrangeReply(10000)
+	b.ReportAllocs()
+	for b.Loop() {
+		io.WriteString(io.Discard, concatenateArray(values))
+	}
+}
+
+func BenchmarkLRange10kEncodeAsArray(b *testing.B) {
+	values := lrangeReply(10000)
+	parser := RedisProtocolParser{}
+	b.ReportAllocs()
+	for b.Loop() {
+		io.WriteString(io.Discard, parser.EncodeAsArray(values))
+	}
+}
+
+func BenchmarkLRange10kWriter(b *testing.B) {
+	values := lrangeReply(10000)
+	w := NewWriter(io.Discard)
+	b.ReportAllocs()
+	for b.Loop() {
+		w.WriteBulkArray(values)
+		w.Flush()
+	}
+}
+
+// pipelined GET replies, one write per reply against one write per batch
+func BenchmarkPipelineUnbuffered(b *testing.B) {
+	parser := RedisProtocolParser{}
+	b.ReportAllocs()
+	for b.Loop() {
+		for range 100 {
+			io.Discard.Write([]byte(parser.EncodeBulkString("value", true)))
+		}
+	}
+}
+
+func BenchmarkPipelineWriter(b *testing.B) {
+	w := NewWriter(io.Discard)
+	b.ReportAllocs()
+	for b.Loop() {
+		for range 100 {
+			w.WriteBulk("value")
+		}
+		w.Flush()
+	}
+}
@@ -5,69 +5,191 @@ import (
 	"io"
 	"log"
 	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
 	command "redisgo/command"
 	network "redisgo/network"
 	protocol "redisgo/protocol"
 )
 
+// the replies of a batch of pipelined commands are sent before the end of the
+// batch once they take more than this many bytes
+const maxPendingReplies = 64 * 1024
+
 type Redis struct {
 	Info     *InstanceInfo
 	Server   *network.TcpServer
 	Parser   protocol.Parser
-	Handlers map[string]command.CommandHandler
+	Commands *command.Table
 	Ctx      context.Context
+	Limits   protocol.Limits
+
+	// gate makes transactions atomic, see run
+	gate sync.RWMutex
+}
+
+// Use adds middlewares that run around every command, see command.Middleware
+func (r *Redis) Use(middlewares ...command.Middleware) {
+	r.Commands.Use(middlewares...)
+}
+
+func (r *Redis) Start() error {
+	return r.Server.Start(r.handleConnection)
 }
 
-func (r *Redis) Start() {
-	r.Server.Start(r.handleConnection)
+// Serve handles the connections accepted by listener
+func (r *Redis) Serve(listener net.Listener) error {
+	return r.Server.Serve(listener, r.handleConnection)
+}
+
+// Shutdown closes the listener and the client connections
+func (r *Redis) Shutdown(ctx context.Context) error {
+	return r.Server.Shutdown(ctx)
 }
 
 func (r *Redis) handleConnection(conn net.Conn) {
-	buff := make([]byte, 1024)
+	// a bug triggered by a single client must not crash the whole server
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("panic serving %s: %v\n%s", conn.RemoteAddr(), rec, debug.Stack())
+		}
+	}()
+
+	reader := protocol.NewReader(conn)
+	reader.Limits = r.Limits
+	client := command.NewClient(conn)
+	defer client.Unwatch()
+	writer := client.Writer
+	ctx := r.Ctx
 	defer conn.Close()
+	defer writer.Flush()
 	for {
-		n, err := conn.Read(buff)
-
+		frame, err := reader.ReadFrame()
 		if err != nil {
-			if err == io.EOF {
-				return
-			}
-			log.Println("error reading data, ", err)
-			return
-		}
-
-		if n == 0 {
-			log.Println("data length: 0")
+			r.handleReadError(err, writer)
 			return
 		}
 
-		data := buff[:n]
-
-		decodedData, err := r.Parser.Decode(data)
+		decodedData, err := r.Parser.Decode(frame)
 		if err != nil {
-			log.Println("error decoding data, ", err)
+			r.handleReadError(err, writer)
 			return
 		}
-		commands, err := command.ExtractCommandsFromParsedData(decodedData)
+		commands, err := command.ExtractCommands(decodedData)
 		if err != nil {
-			log.Println("error extracting commands, ", err)
+			r.handleReadError(err, writer)
 			return
 		}
 
 		for _, c := range commands {
 			log.Printf("Received command: %s with args: %v\n", c.Name, c.Args)
-			if handler, ok := r.Handlers[c.Name]; ok {
-				err := handler.Execute(c.Args, &r.Ctx, conn)
-				if err != nil {
-					log.Println("error executing command, ", err)
+			if err := r.execute(c, &ctx, client); err != nil {
+				log.Println("error executing command, ", err)
+				return
+			}
+			if writer.Buffered() > maxPendingReplies {
+				if err := writer.Flush(); err != nil {
+					log.Println("error writing data, ", err)
 					return
 				}
+			}
+		}
 
-			} else {
-				log.Printf("Unknown command: %s\n", c.Name)
-				conn.Write([]byte("-ERR unknown command '" + c.Name + "'\r\n"))
+		// the replies of a batch of pipelined commands are sent together
+		// once all the frames already received have been executed
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				log.Println("error writing data, ", err)
+				return
 			}
 		}
 
 	}
 }
+
+// execute runs a command, command errors are sent to the client as error
+// replies, any other error means that the connection can't be used anymore
+func (r *Redis) execute(c command.Cmd, ctx *context.Context, client *command.Client) error {
+	writer := client.Writer
+	client.LastInteraction = time.Now()
+
+	cmd, ok := r.Commands.Lookup(c.Name)
+	var err error
+	if !ok {
+		log.Printf("Unknown command: %s\n", c.Name)
+		err = command.NewError("unknown command '%s'", c.Name)
+	} else {
+		err = cmd.Validate(c.Args)
+	}
+
+	// after MULTI the commands are queued until EXEC, a command that can't
+	// be queued makes the whole transaction fail
+	if client.HasFlag(command.ClientMulti) && !command.IsTransactionCommand(c.Name) {
+		if err != nil {
+			client.Flags |= command.ClientDirtyExec
+		} else {
+			client.Queue(cmd, c.Args)
+			return writer.WriteSimpleString("QUEUED")
+		}
+	}
+
+	// a blocking command can wait for a long time, the replies of the
+	// pipelined commands received before it are sent first
+	if err == nil && cmd.HasFlag(command.FlagBlocking) {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if err == nil {
+		client.Cmd = cmd
+		client.LastCommand = cmd.Name
+		err = r.run(cmd, c.Args, ctx, client)
+	}
+	if cmdErr, ok := command.AsCommandError(err); ok {
+		return writer.WriteError(cmdErr.Msg)
+	}
+	return err
+}
+
+// run executes the command holding the gate: transactions, scripts and write
+// commands hold it exclusively, so transactions and scripts are atomic and
+// writes are propagated in the order they are applied. The other commands
+// share it. Blocking commands release it only while they wait, see
+// command.Client.Block.
+func (r *Redis) run(cmd *command.Command, args []string, ctx *context.Context, client *command.Client) error {
+	var gate sync.Locker
+	switch {
+	case cmd.Name == protocol.EXEC, cmd.Name == protocol.EVAL, cmd.Name == protocol.EVALSHA,
+		cmd.Name == protocol.FCALL, cmd.Name == protocol.FCALL_RO:
+		gate = &r.gate
+	case (cmd.Name == protocol.SCRIPT || cmd.Name == protocol.FUNCTION) && strings.EqualFold(args[0], "kill"):
+		// SCRIPT KILL and FUNCTION KILL must be able to run while a script
+		// holds the gate
+	case cmd.Name == protocol.SCRIPT, cmd.Name == protocol.FUNCTION, cmd.HasFlag(command.FlagWrite):
+		gate = &r.gate
+	default:
+		gate = r.gate.RLocker()
+	}
+	if gate != nil {
+		gate.Lock()
+		defer gate.Unlock()
+	}
+	client.Gate = gate
+	return cmd.Execute(args, ctx, client)
+}
+
+// handleReadError replies to protocol errors before the connection is closed,
+// I/O errors are only logged
+func (r *Redis) handleReadError(err error, writer *protocol.Writer) {
+	if protocol.IsProtocolError(err) {
+		log.Println("protocol error, ", err)
+		writer.WriteError("ERR " + err.Error())
+		return
+	}
+	if err != io.EOF {
+		log.Println("error reading data, ", err)
+	}
+}
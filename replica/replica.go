@@ -1,26 +1,28 @@
 package negotiation
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"net"
 	"redisgo/protocol"
+	"strconv"
+	"strings"
 )
 
 type ReplicaController struct {
-	replicas []Replica
-	parser   protocol.Parser
+	replicas         []Replica
+	parser           protocol.Parser
+	masterReplId     string
+	masterReplOffset int
 }
 
-// TODO: remove hardcoded content
-var BASE64_EMPTY_RDB_FILE = "UkVESVMwMDEx+glyZWRpcy12ZXIFNy4yLjD6CnJlZGlzLWJpdHPAQPoFY3RpbWXCbQi8ZfoIdXNlZC1tZW3CsMQQAPoIYW9mLWJhc2XAAP/wbjv+wP9aog=="
-
 func (rc *ReplicaController) Setup(port string, host string) error {
 	conn, err := net.Dial("tcp", host+":"+port)
-	buff := make([]byte, 1024)
 	if err != nil {
 		return err
 	}
+	reader := protocol.NewReader(conn)
 
 	// send PING
 	pingCmd := rc.parser.EncodeAsArray([]string{"PING"})
@@ -29,15 +31,8 @@ func (rc *ReplicaController) Setup(port string, host string) error {
 	}
 
 	// wait for PONG
-	n, err := conn.Read(buff)
-	if err != nil {
-		return err
-	}
-
-	if parsedResp, err := rc.parser.Decode(buff[:n]); err != nil {
+	if err := rc.expectSimpleString(reader, "PONG"); err != nil {
 		return err
-	} else if string(parsedResp[0]) != "PONG" {
-		return fmt.Errorf("unexpected response the master, got \"%v\", expected \"PONG\"\n", parsedResp)
 	}
 
 	// send port with REPLCONF listening-port command
@@ -48,15 +43,8 @@ func (rc *ReplicaController) Setup(port string, host string) error {
 	}
 
 	// wait "OK"
-
-	n, err = conn.Read(buff)
-	if err != nil {
-		return err
-	}
-	if parsedResp, err := rc.parser.Decode(buff[:n]); err != nil {
+	if err := rc.expectSimpleString(reader, "OK"); err != nil {
 		return err
-	} else if string(parsedResp[0]) != "OK" {
-		return fmt.Errorf("unexpected response the master, got \"%v\", expected \"PONG\"\n", parsedResp)
 	}
 
 	// send capability sync2
@@ -67,14 +55,8 @@ func (rc *ReplicaController) Setup(port string, host string) error {
 	}
 
 	// wait "OK"
-	n, err = conn.Read(buff)
-	if err != nil {
-		return err
-	}
-	if parsedResp, err := rc.parser.Decode(buff[:n]); err != nil {
+	if err := rc.expectSimpleString(reader, "OK"); err != nil {
 		return err
-	} else if string(parsedResp[0]) != "OK" {
-		return fmt.Errorf("unexpected response the master, got \"%v\", expected \"PONG\"", parsedResp)
 	}
 
 	// Send PSYNC ? -1
@@ -83,35 +65,32 @@ func (rc *ReplicaController) Setup(port string, host string) error {
 		return err
 	}
 
-	// wait FULLRESYNC
-	n, err = conn.Read(buff)
+	// wait FULLRESYNC <replid> <offset>
+	fullReSync, err := rc.readResponse(reader)
 	if err != nil {
 		return err
 	}
+	fields := strings.Fields(fullReSync.Str)
+	if fullReSync.Type != protocol.SIMPLE_STRINGS || len(fields) != 3 || strings.ToLower(fields[0]) != protocol.FULLRESYNC {
+		return fmt.Errorf("unexpected response the master, got \"%v\", expected \"FULLRESYNC <replid> <offset>\"", fullReSync.Str)
+	}
 
-	fullReSync, err := rc.parser.Decode(buff[:n])
+	offset, err := strconv.Atoi(fields[2])
 	if err != nil {
-		return err
-	} else if len(fullReSync) != 3 {
-		return fmt.Errorf("unexpected number of parameters for the FULLRESYNC command, got %d, expected %d", len(fullReSync), 3)
+		return fmt.Errorf("invalid FULLRESYNC offset \"%s\"", fields[2])
 	}
+	rc.masterReplId = fields[1]
+	rc.masterReplOffset = offset
 
-	// TODO: set master info
-	//masterReplId := fullReSync[0]
-	//masterReplOffset := fullReSync[1]
-
-	// wait RDB file
-
-	n, err = conn.Read(buff)
+	// wait RDB file, it's sent as a bulk string without the final CRLF
+	rdbFileContent, err := reader.ReadRawBulk()
 	if err != nil {
 		return err
 	}
 
 	// TODO: decode RDB file content
-	if rdbFileContent, err := rc.parser.Decode(buff[:n]); err != nil {
-		return err
-	} else if rdbFileContent[0] != BASE64_EMPTY_RDB_FILE {
-		return fmt.Errorf("unexpected data, got \"%s\", expected \"%s\"", rdbFileContent[0], BASE64_EMPTY_RDB_FILE)
+	if !bytes.HasPrefix(rdbFileContent, []byte("REDIS")) {
+		return fmt.Errorf("unexpected data, got \"%q\", expected an RDB file", rdbFileContent)
 	}
 
 	log.Println("[ReplicaController] Replica setup completed successfully")
@@ -120,5 +99,32 @@ func (rc *ReplicaController) Setup(port string, host string) error {
 
 }
 
+// readResponse reads the next frame sent by the master and returns the first value
+func (rc *ReplicaController) readResponse(reader *protocol.Reader) (protocol.Value, error) {
+	frame, err := reader.ReadFrame()
+	if err != nil {
+		return protocol.Value{}, err
+	}
+	values, err := rc.parser.Decode(frame)
+	if err != nil {
+		return protocol.Value{}, err
+	}
+	if len(values) == 0 {
+		return protocol.Value{}, fmt.Errorf("empty response from the master")
+	}
+	return values[0], nil
+}
+
+func (rc *ReplicaController) expectSimpleString(reader *protocol.Reader, expected string) error {
+	resp, err := rc.readResponse(reader)
+	if err != nil {
+		return err
+	}
+	if resp.Type != protocol.SIMPLE_STRINGS || resp.Str != expected {
+		return fmt.Errorf("unexpected response the master, got \"%v\", expected \"%s\"", resp.Str, expected)
+	}
+	return nil
+}
+
 type Replica struct {
 }
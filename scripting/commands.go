@@ -0,0 +1,326 @@
+package scripting
+
+import (
+	"context"
+	command "redisgo/command"
+	protocol "redisgo/protocol"
+	utils "redisgo/utils"
+	"strconv"
+	"strings"
+)
+
+var scriptHelp = []string{
+	"SCRIPT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"EXISTS <sha1> [<sha1> ...]",
+	"    Return information about the existence of the scripts in the script cache.",
+	"FLUSH [ASYNC|SYNC]",
+	"    Flush the Lua scripts cache.",
+	"KILL",
+	"    Kill the currently executing Lua script.",
+	"LOAD <script>",
+	"    Load a script into the scripts cache without executing it.",
+	"HELP",
+	"    Print this help.",
+}
+
+// EVAL script numkeys [key ...] [arg ...]
+type Eval struct {
+	Engine *Engine
+}
+
+func (h *Eval) Execute(args []string, ctx *context.Context, client *command.Client) error {
+	keys, argv, err := splitKeys(args)
+	if err != nil {
+		return err
+	}
+	sha, err := h.Engine.Load(args[0])
+	if err != nil {
+		return err
+	}
+	return h.Engine.EvalSha(sha, keys, argv, ctx, client)
+}
+
+// EVALSHA sha1 numkeys [key ...] [arg ...]
+type EvalSha struct {
+	Engine *Engine
+}
+
+func (h *EvalSha) Execute(args []string, ctx *context.Context, client *command.Client) error {
+	keys, argv, err := splitKeys(args)
+	if err != nil {
+		return err
+	}
+	return h.Engine.EvalSha(args[0], keys, argv, ctx, client)
+}
+
+// EvalKeys returns the keys of EVAL and EVALSHA, they follow numkeys
+func EvalKeys(args []string) []string {
+	keys, _, err := splitKeys(args)
+	if err != nil {
+		return nil
+	}
+	return keys
+}
+
+// splitKeys parses the numkeys argument of EVAL and returns the keys and
+// the arguments that follow it
+func splitKeys(args []string) (keys, argv []string, err error) {
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, nil, command.ErrNotInteger
+	}
+	if numKeys < 0 {
+		return nil, nil, command.NewError("Number of keys can't be negative")
+	}
+	if numKeys > len(args)-2 {
+		return nil, nil, command.NewError("Number of keys can't be greater than number of args")
+	}
+	return args[2 : 2+numKeys], args[2+numKeys:], nil
+}
+
+// SCRIPT manages the script cache
+type Script struct {
+	Engine *Engine
+}
+
+func (h *Script) Execute(args []string, ctx *context.Context, client *command.Client) error {
+	w := client.Writer
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "load" && len(args) == 2:
+		sha, err := h.Engine.Load(args[1])
+		if err != nil {
+			return err
+		}
+		return w.WriteBulk(sha)
+
+	case sub == "exists" && len(args) > 1:
+		w.WriteArrayHeader(len(args) - 1)
+		for _, sha := range args[1:] {
+			if h.Engine.Exists(sha) {
+				w.WriteInt(1)
+			} else {
+				w.WriteInt(0)
+			}
+		}
+		return nil
+
+	case sub == "flush" && len(args) <= 2:
+		if len(args) == 2 {
+			if mode := strings.ToLower(args[1]); mode != "async" && mode != "sync" {
+				return command.ErrSyntax
+			}
+		}
+		h.Engine.Flush()
+		return w.WriteOK()
+
+	case sub == "kill" && len(args) == 1:
+		if err := h.Engine.Kill(false); err != nil {
+			return err
+		}
+		return w.WriteOK()
+
+	case sub == "help" && len(args) == 1:
+		w.WriteArrayHeader(len(scriptHelp))
+		for _, line := range scriptHelp {
+			w.WriteSimpleString(line)
+		}
+		return nil
+
+	default:
+		return command.NewError("unknown subcommand or wrong number of arguments for '%s'. Try SCRIPT HELP.", args[0])
+	}
+}
+
+// FCALL function numkeys [key ...] [arg ...], FCALL_RO when ReadOnly is set
+type FCall struct {
+	Engine   *Engine
+	ReadOnly bool
+}
+
+func (h *FCall) Execute(args []string, ctx *context.Context, client *command.Client) error {
+	keys, argv, err := splitKeys(args)
+	if err != nil {
+		return err
+	}
+	return h.Engine.FCall(args[0], keys, argv, h.ReadOnly, ctx, client)
+}
+
+var functionHelp = []string{
+	"FUNCTION <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"LOAD [REPLACE] <FUNCTION CODE>",
+	"    Create a new library with the given library name and code.",
+	"DELETE <LIBRARY NAME>",
+	"    Delete the given library.",
+	"LIST [LIBRARYNAME PATTERN] [WITHCODE]",
+	"    Return general information on all the libraries:",
+	"    * Library name",
+	"    * The engine used to run the Library",
+	"    * Library description",
+	"    * Functions list",
+	"    * Library code (if WITHCODE is given)",
+	"    It also possible to get only function that matches a pattern using LIBRARYNAME argument.",
+	"KILL",
+	"    Kill the current running function.",
+	"FLUSH [ASYNC|SYNC]",
+	"    Delete all the libraries.",
+	"DUMP",
+	"    Return a serialized payload representing the current libraries, can be restored using FUNCTION RESTORE command",
+	"RESTORE <PAYLOAD> [FLUSH|APPEND|REPLACE]",
+	"    Restore the libraries represented by the given payload, it is possible to give a restore policy to",
+	"    control how to handle existing libraries (default APPEND):",
+	"    * FLUSH: delete all existing libraries.",
+	"    * APPEND: appends the restored libraries to the existing libraries. On collision, abort.",
+	"    * REPLACE: appends the restored libraries to the existing libraries, On collision, replace the old",
+	"      libraries with the new libraries (notice that even on this option there is a chance of failure",
+	"      in case of functions name collision with another library).",
+	"HELP",
+	"    Print this help.",
+}
+
+// FUNCTION manages the libraries of functions. The subcommands that change
+// the libraries are propagated, so replicas and persistence logs get them.
+type FunctionCmd struct {
+	Engine *Engine
+}
+
+func (h *FunctionCmd) Execute(args []string, ctx *context.Context, client *command.Client) error {
+	w := client.Writer
+	sub := strings.ToLower(args[0])
+	switch {
+	case sub == "load" && (len(args) == 2 || len(args) == 3):
+		replace := false
+		if len(args) == 3 {
+			if !strings.EqualFold(args[1], "replace") {
+				return command.NewError("Unknown option given: %s", args[1])
+			}
+			replace = true
+		}
+		name, err := h.Engine.LoadLibrary(args[len(args)-1], replace)
+		if err != nil {
+			return err
+		}
+		client.Propagate(append([]string{protocol.FUNCTION}, args...)...)
+		return w.WriteBulk(name)
+
+	case sub == "delete" && len(args) == 2:
+		if err := h.Engine.DeleteLibrary(args[1]); err != nil {
+			return err
+		}
+		client.Propagate(append([]string{protocol.FUNCTION}, args...)...)
+		return w.WriteOK()
+
+	case sub == "list":
+		return h.list(args[1:], w)
+
+	case sub == "dump" && len(args) == 1:
+		return w.WriteBulk(h.Engine.Dump())
+
+	case sub == "restore" && (len(args) == 2 || len(args) == 3):
+		policy := "append"
+		if len(args) == 3 {
+			policy = strings.ToLower(args[2])
+			if policy != "flush" && policy != "append" && policy != "replace" {
+				return command.NewError("Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+			}
+		}
+		if err := h.Engine.Restore(args[1], policy); err != nil {
+			return err
+		}
+		client.Propagate(append([]string{protocol.FUNCTION}, args...)...)
+		return w.WriteOK()
+
+	case sub == "flush" && len(args) <= 2:
+		if len(args) == 2 {
+			if mode := strings.ToLower(args[1]); mode != "async" && mode != "sync" {
+				return command.ErrSyntax
+			}
+		}
+		h.Engine.FlushLibraries()
+		client.Propagate(append([]string{protocol.FUNCTION}, args...)...)
+		return w.WriteOK()
+
+	case sub == "kill" && len(args) == 1:
+		if err := h.Engine.Kill(true); err != nil {
+			return err
+		}
+		return w.WriteOK()
+
+	case sub == "help" && len(args) == 1:
+		w.WriteArrayHeader(len(functionHelp))
+		for _, line := range functionHelp {
+			w.WriteSimpleString(line)
+		}
+		return nil
+
+	default:
+		return command.NewError("unknown subcommand or wrong number of arguments for '%s'. Try FUNCTION HELP.", args[0])
+	}
+}
+
+// list implements FUNCTION LIST [LIBRARYNAME pattern] [WITHCODE]
+func (h *FunctionCmd) list(args []string, w *protocol.Writer) error {
+	withCode := false
+	pattern := ""
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "withcode":
+			withCode = true
+		case "libraryname":
+			if i+1 == len(args) {
+				return command.NewError("library name argument was not given")
+			}
+			i++
+			pattern = args[i]
+		default:
+			return command.NewError("Unknown argument %s", args[i])
+		}
+	}
+
+	libs := h.Engine.Libraries()
+	if pattern != "" {
+		matching := libs[:0]
+		for _, lib := range libs {
+			if utils.MatchGlob(pattern, lib.Name) {
+				matching = append(matching, lib)
+			}
+		}
+		libs = matching
+	}
+
+	w.WriteArrayHeader(len(libs))
+	for _, lib := range libs {
+		if withCode {
+			w.WriteMapHeader(4)
+		} else {
+			w.WriteMapHeader(3)
+		}
+		w.WriteBulk("library_name")
+		w.WriteBulk(lib.Name)
+		w.WriteBulk("engine")
+		w.WriteBulk("LUA")
+		w.WriteBulk("functions")
+		w.WriteArrayHeader(len(lib.Functions))
+		for _, f := range lib.Functions {
+			w.WriteMapHeader(3)
+			w.WriteBulk("name")
+			w.WriteBulk(f.Name)
+			w.WriteBulk("description")
+			if f.Description == "" {
+				w.WriteNull()
+			} else {
+				w.WriteBulk(f.Description)
+			}
+			w.WriteBulk("flags")
+			w.WriteSetHeader(len(f.Flags))
+			for _, flag := range f.Flags {
+				w.WriteBulk(flag)
+			}
+		}
+		if withCode {
+			w.WriteBulk("library_code")
+			w.WriteBulk(lib.Code)
+		}
+	}
+	return nil
+}
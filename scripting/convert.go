@@ -0,0 +1,168 @@
+package scripting
+
+import (
+	command "redisgo/command"
+	protocol "redisgo/protocol"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// toLua converts the reply of a command to the Lua value returned by
+// redis.call:
+//
+//	integer                      -> number
+//	bulk string                  -> string
+//	simple string                -> table with an ok field
+//	error                        -> table with an err field
+//	array                        -> array table
+//	null bulk string, null array -> false
+//	RESP3 null                   -> nil
+//	boolean                      -> boolean
+//	double                       -> table with a double field
+//	big number                   -> table with a big_number field
+//	verbatim string              -> table with a verbatim_string field
+//	map                          -> table with a map field
+//	set                          -> table with a set field
+func toLua(L *lua.LState, v protocol.Value) lua.LValue {
+	switch v.Type {
+	case protocol.INTEGERS:
+		return lua.LNumber(v.Int)
+	case protocol.BULK_STRINGS:
+		if v.Null {
+			return lua.LFalse
+		}
+		return lua.LString(v.Str)
+	case protocol.SIMPLE_STRINGS:
+		return fieldTable(L, "ok", lua.LString(v.Str))
+	case protocol.SIMPLE_ERRORS, protocol.BULK_ERRORS:
+		return errorTable(L, v.Str)
+	case protocol.ARRAY, protocol.PUSHES:
+		if v.Null {
+			return lua.LFalse
+		}
+		t := L.CreateTable(len(v.Array), 0)
+		for _, item := range v.Array {
+			t.Append(toLua(L, item))
+		}
+		return t
+	case protocol.BOOLEANS:
+		return lua.LBool(v.Bool)
+	case protocol.DOUBLES:
+		return fieldTable(L, "double", lua.LNumber(v.Float))
+	case protocol.BIG_NUMBERS:
+		return fieldTable(L, "big_number", lua.LString(v.Str))
+	case protocol.VERBATIM_STRINGS:
+		format, s, _ := strings.Cut(v.Str, ":")
+		t := L.NewTable()
+		t.RawSetString("format", lua.LString(format))
+		t.RawSetString("string", lua.LString(s))
+		return fieldTable(L, "verbatim_string", t)
+	case protocol.MAPS:
+		t := L.NewTable()
+		for i := 0; i+1 < len(v.Array); i += 2 {
+			t.RawSet(toLua(L, v.Array[i]), toLua(L, v.Array[i+1]))
+		}
+		return fieldTable(L, "map", t)
+	case protocol.SETS:
+		t := L.NewTable()
+		for _, item := range v.Array {
+			t.RawSet(toLua(L, item), lua.LTrue)
+		}
+		return fieldTable(L, "set", t)
+	}
+	return lua.LNil
+}
+
+func fieldTable(L *lua.LState, field string, value lua.LValue) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString(field, value)
+	return t
+}
+
+// the tables returned by a script can't be nested deeper than this, a table
+// that contains itself would never end
+const maxReplyDepth = 1000
+
+var errReplyTooDeep = command.NewError("reached lua stack limit")
+
+// writeReply converts the value returned by a script to a reply, it is the
+// inverse of toLua. Numbers are truncated to integers and the array part of
+// a table ends at the first nil. An error table returned by the script is
+// the error reply of EVAL. Nothing is written if the value can't be
+// converted.
+func writeReply(w *protocol.Writer, lv lua.LValue) error {
+	if t, ok := lv.(*lua.LTable); ok {
+		if msg, ok := t.RawGetString("err").(lua.LString); ok {
+			return &command.CommandError{Msg: string(msg)}
+		}
+	}
+	v, err := replyValue(lv, w.Protocol, 0)
+	if err != nil {
+		return err
+	}
+	return w.WriteValue(v)
+}
+
+func replyValue(lv lua.LValue, version int, depth int) (protocol.Value, error) {
+	switch v := lv.(type) {
+	case lua.LNumber:
+		return protocol.Integer(int64(v)), nil
+	case lua.LString:
+		return protocol.BulkString(string(v)), nil
+	case lua.LBool:
+		if v || version == protocol.RESP3 {
+			return protocol.Boolean(bool(v)), nil
+		}
+		return protocol.NullBulkString(), nil
+	case *lua.LTable:
+		if depth >= maxReplyDepth {
+			return protocol.Value{}, errReplyTooDeep
+		}
+		return replyTable(v, version, depth+1)
+	}
+	return protocol.NullBulkString(), nil
+}
+
+func replyTable(t *lua.LTable, version int, depth int) (protocol.Value, error) {
+	if msg, ok := t.RawGetString("err").(lua.LString); ok {
+		return protocol.Error(string(msg)), nil
+	}
+	if msg, ok := t.RawGetString("ok").(lua.LString); ok {
+		return protocol.SimpleString(string(msg)), nil
+	}
+	if d, ok := t.RawGetString("double").(lua.LNumber); ok {
+		return protocol.Double(float64(d)), nil
+	}
+	if n, ok := t.RawGetString("big_number").(lua.LString); ok {
+		return protocol.BigNumber(string(n)), nil
+	}
+
+	var items []lua.LValue
+	typ := protocol.ARRAY
+	if m, ok := t.RawGetString("map").(*lua.LTable); ok {
+		typ = protocol.MAPS
+		m.ForEach(func(k, v lua.LValue) {
+			items = append(items, k, v)
+		})
+	} else if s, ok := t.RawGetString("set").(*lua.LTable); ok {
+		typ = protocol.SETS
+		s.ForEach(func(k, _ lua.LValue) {
+			items = append(items, k)
+		})
+	} else {
+		for i := 1; t.RawGetInt(i) != lua.LNil; i++ {
+			items = append(items, t.RawGetInt(i))
+		}
+	}
+
+	values := make([]protocol.Value, len(items))
+	for i, item := range items {
+		v, err := replyValue(item, version, depth)
+		if err != nil {
+			return protocol.Value{}, err
+		}
+		values[i] = v
+	}
+	return protocol.Value{Type: typ, Array: values}, nil
+}
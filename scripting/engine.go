@@ -0,0 +1,484 @@
+// Package scripting runs Lua scripts inside the server. Scripts call the
+// commands of a command.Table with redis.call and redis.pcall, and are
+// executed one at a time, so no other command sees the data in the middle of
+// a script.
+package scripting
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"log"
+	command "redisgo/command"
+	protocol "redisgo/protocol"
+	"strconv"
+	"strings"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+var (
+	ErrNoScript       = &command.CommandError{Msg: "NOSCRIPT No matching script. Please use EVAL."}
+	ErrNotBusy        = &command.CommandError{Msg: "NOTBUSY No scripts in execution right now."}
+	ErrUnkillable     = &command.CommandError{Msg: "UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command."}
+	ErrKilled         = &command.CommandError{Msg: "ERR Script killed by user with SCRIPT KILL..."}
+	ErrFunctionKilled = &command.CommandError{Msg: "ERR Script killed by user with FUNCTION KILL..."}
+)
+
+// Engine keeps the Lua interpreter, the cache of the scripts loaded with
+// EVAL and SCRIPT LOAD and the libraries loaded with FUNCTION LOAD
+type Engine struct {
+	Table *command.Table
+
+	// runMu serializes the scripts, the interpreter isn't safe for
+	// concurrent use
+	runMu sync.Mutex
+	L     *lua.LState
+	// read-only proxies of the globals and the libraries, and the tables
+	// they proxy
+	proxies map[*lua.LTable]*lua.LTable
+
+	mu        sync.Mutex
+	scripts   map[string]*lua.FunctionProto
+	libraries map[string]*Library
+	functions map[string]*Function
+	running   *execution
+
+	// loading is the library being loaded by FUNCTION LOAD, it is only
+	// accessed with runMu held
+	loading *Library
+}
+
+// execution is the state of the script being run
+type execution struct {
+	ctx    *context.Context
+	client *command.Client
+	buf    bytes.Buffer
+	cancel context.CancelFunc
+
+	// readOnly scripts can't call write commands
+	readOnly bool
+	// function is set when running a function called by FCALL instead of an
+	// EVAL script, each one is killed by its own KILL subcommand
+	function bool
+	// wrote is set once the script calls a write command, it can't be killed
+	// after that
+	wrote  bool
+	killed bool
+}
+
+func NewEngine(table *command.Table) *Engine {
+	e := &Engine{
+		Table:     table,
+		scripts:   make(map[string]*lua.FunctionProto),
+		libraries: make(map[string]*Library),
+		functions: make(map[string]*Function),
+		proxies:   make(map[*lua.LTable]*lua.LTable),
+	}
+	e.L = e.newState()
+	return e
+}
+
+// newState returns an interpreter with the safe subset of the standard
+// library and the redis module. The interpreter is shared by all the scripts,
+// so they can't modify the globals or the libraries: scripts see them through
+// read-only proxies and can't create global variables.
+func (e *Engine) newState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// functions that access files or change the environment of functions
+	for _, name := range []string{"dofile", "loadfile", "print", "getfenv", "setfenv", "module", "require", "_printregs"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call":         func(L *lua.LState) int { return e.call(L, true) },
+		"pcall":        func(L *lua.LState) int { return e.call(L, false) },
+		"error_reply":  errorReply,
+		"status_reply": statusReply,
+		"sha1hex":      sha1hex,
+		"log":          logMessage,
+		"setresp":      e.setResp,
+
+		"register_function": e.registerFunction,
+	})
+	for i, level := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		redis.RawSetString(level, lua.LNumber(i))
+	}
+	globals := L.G.Global
+	globals.RawSetString("redis", e.readOnly(L, redis, modifyReadOnly))
+	globals.RawSetString("KEYS", L.NewTable())
+	globals.RawSetString("ARGV", L.NewTable())
+	for _, lib := range libs[1:] {
+		globals.RawSetString(lib.name, e.readOnly(L, globals.RawGetString(lib.name).(*lua.LTable), modifyReadOnly))
+	}
+
+	// rawset and rawget would bypass the proxies, they act on the proxied
+	// table instead. setmetatable and getmetatable can't change or get the
+	// locked metatables of the proxies.
+	globals.RawSetString("rawset", L.NewFunction(func(L *lua.LState) int {
+		t := L.CheckTable(1)
+		if _, ok := e.proxies[t]; ok {
+			L.RaiseError("Attempt to modify a readonly table")
+		}
+		L.RawSet(t, L.CheckAny(2), L.CheckAny(3))
+		L.SetTop(1)
+		return 1
+	}))
+	globals.RawSetString("rawget", L.NewFunction(func(L *lua.LState) int {
+		t := L.CheckTable(1)
+		if target, ok := e.proxies[t]; ok {
+			t = target
+		}
+		L.Push(L.RawGet(t, L.CheckAny(2)))
+		return 1
+	}))
+	// the methods of the strings use the string library directly
+	L.GetMetatable(lua.LString("")).(*lua.LTable).RawSetString("__metatable", lua.LFalse)
+
+	guard := L.NewTable()
+	guard.RawSetString("__index", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to access nonexistent global variable '%s'", L.Get(2).String())
+		return 0
+	}))
+	L.SetMetatable(globals, guard)
+
+	// the scripts run with the proxy of the globals as their environment
+	env := e.readOnly(L, globals, func(L *lua.LState) int {
+		if globals.RawGet(L.Get(2)) != lua.LNil {
+			return modifyReadOnly(L)
+		}
+		L.RaiseError("Script attempted to create global variable '%s'", L.Get(2).String())
+		return 0
+	})
+	globals.RawSetString("_G", env)
+	L.Env = env
+	return L
+}
+
+// readOnly returns a proxy of t, reading the proxy reads t and writing to it
+// calls newIndex. The metatable of the proxy is locked.
+func (e *Engine) readOnly(L *lua.LState, t *lua.LTable, newIndex lua.LGFunction) *lua.LTable {
+	mt := L.NewTable()
+	mt.RawSetString("__index", t)
+	mt.RawSetString("__newindex", L.NewFunction(newIndex))
+	mt.RawSetString("__metatable", lua.LFalse)
+	proxy := L.NewTable()
+	L.SetMetatable(proxy, mt)
+	e.proxies[proxy] = t
+	return proxy
+}
+
+func modifyReadOnly(L *lua.LState) int {
+	L.RaiseError("Attempt to modify a readonly table")
+	return 0
+}
+
+// SHA1 returns the hex digest used to refer to a script
+func SHA1(body string) string {
+	sum := sha1.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// Load compiles a script and adds it to the cache, it returns its SHA1
+func (e *Engine) Load(body string) (string, error) {
+	sha := SHA1(body)
+	if _, ok := e.lookup(sha); ok {
+		return sha, nil
+	}
+	proto, err := compile(body, "user_script")
+	if err != nil {
+		return "", command.NewError("Error compiling script (new function): %s", err)
+	}
+	e.mu.Lock()
+	e.scripts[sha] = proto
+	e.mu.Unlock()
+	return sha, nil
+}
+
+func (e *Engine) lookup(sha string) (*lua.FunctionProto, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	proto, ok := e.scripts[strings.ToLower(sha)]
+	return proto, ok
+}
+
+// Exists reports whether the script with the given SHA1 is in the cache
+func (e *Engine) Exists(sha string) bool {
+	_, ok := e.lookup(sha)
+	return ok
+}
+
+// Flush empties the script cache
+func (e *Engine) Flush() {
+	e.mu.Lock()
+	e.scripts = make(map[string]*lua.FunctionProto)
+	e.mu.Unlock()
+}
+
+// Kill stops the script being run, an EVAL script if function is false or a
+// function called by FCALL otherwise. Scripts that already modified the data
+// can't be killed.
+func (e *Engine) Kill(function bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch {
+	case e.running == nil, e.running.function != function:
+		return ErrNotBusy
+	case e.running.wrote:
+		return ErrUnkillable
+	}
+	e.running.killed = true
+	e.running.cancel()
+	return nil
+}
+
+// EvalSha runs a cached script and writes its result to client
+func (e *Engine) EvalSha(sha string, keys, argv []string, ctx *context.Context, client *command.Client) error {
+	proto, ok := e.lookup(sha)
+	if !ok {
+		return ErrNoScript
+	}
+	return e.run(false, false, ctx, client, func(L *lua.LState) int {
+		L.G.Global.RawSetString("KEYS", stringsTable(L, keys))
+		L.G.Global.RawSetString("ARGV", stringsTable(L, argv))
+		L.Push(L.NewFunctionFromProto(proto))
+		return 0
+	})
+}
+
+func compile(body, name string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(body), name)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, name)
+}
+
+// run calls the function pushed by push, which returns the number of
+// arguments it pushed after the function. The value returned by the function
+// is converted to the reply of the client. function tells if the code is a
+// function called by FCALL instead of an EVAL script.
+func (e *Engine) run(readOnly, function bool, ctx *context.Context, client *command.Client, push func(L *lua.LState) int) error {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exec := &execution{ctx: ctx, cancel: cancel, readOnly: readOnly, function: function}
+	// the commands called by the script run as the caller but write their
+	// replies to a buffer, blocking commands return immediately like inside
+	// MULTI
+	exec.client = client.Nested(protocol.NewWriter(&exec.buf))
+	// the writes of the script are propagated together with the caller's
+	// command, inside MULTI/EXEC
+	client.PropagateTransaction()
+
+	e.mu.Lock()
+	e.running = exec
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.running = nil
+		e.mu.Unlock()
+	}()
+
+	L := e.L
+	nargs := push(L)
+	L.SetContext(runCtx)
+	err := L.PCall(nargs, 1, nil)
+	L.RemoveContext()
+
+	if err != nil {
+		e.mu.Lock()
+		killed := exec.killed
+		e.mu.Unlock()
+		if killed && function {
+			return ErrFunctionKilled
+		}
+		if killed {
+			return ErrKilled
+		}
+		return scriptError(err, "Error running script")
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	return writeReply(client.Writer, ret)
+}
+
+// scriptError converts the error raised by a script to a command error that
+// starts with prefix, the errors returned by redis.call are sent as they are
+func scriptError(err error, prefix string) error {
+	var apiErr *lua.ApiError
+	if !errors.As(err, &apiErr) {
+		return command.NewError("%s: %s", prefix, err)
+	}
+	if t, ok := apiErr.Object.(*lua.LTable); ok {
+		if msg, ok := t.RawGetString("err").(lua.LString); ok {
+			return &command.CommandError{Msg: string(msg)}
+		}
+	}
+	return command.NewError("%s: %s", prefix, apiErr.Object.String())
+}
+
+func stringsTable(L *lua.LState, values []string) *lua.LTable {
+	t := L.CreateTable(len(values), 0)
+	for _, v := range values {
+		t.Append(lua.LString(v))
+	}
+	return t
+}
+
+// call implements redis.call and redis.pcall, errors are raised by call and
+// returned as an error table by pcall
+func (e *Engine) call(L *lua.LState, raise bool) int {
+	reply := e.dispatch(L)
+	if raise {
+		if t, ok := reply.(*lua.LTable); ok && t.RawGetString("err") != lua.LNil {
+			L.Error(t, 0)
+		}
+	}
+	L.Push(reply)
+	return 1
+}
+
+func (e *Engine) dispatch(L *lua.LState) lua.LValue {
+	e.mu.Lock()
+	exec := e.running
+	e.mu.Unlock()
+	if exec == nil {
+		return errorTable(L, "ERR redis.call can only be called inside a script invocation")
+	}
+
+	n := L.GetTop()
+	if n == 0 {
+		return errorTable(L, "ERR Please specify at least one argument for this redis lib call")
+	}
+	args := make([]string, n)
+	for i := 1; i <= n; i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString:
+			args[i-1] = string(v)
+		case lua.LNumber:
+			args[i-1] = formatNumber(v)
+		default:
+			return errorTable(L, "ERR Lua redis lib command arguments must be strings or integers")
+		}
+	}
+
+	cmd, ok := e.Table.Lookup(strings.ToLower(args[0]))
+	if !ok {
+		return errorTable(L, "ERR Unknown Redis command called from script")
+	}
+	if cmd.HasFlag(command.FlagNoScript) {
+		return errorTable(L, "ERR This Redis command is not allowed from script")
+	}
+	if cmd.Validate(args[1:]) != nil {
+		return errorTable(L, "ERR Wrong number of args calling Redis command from script")
+	}
+	if cmd.HasFlag(command.FlagWrite) {
+		if exec.readOnly {
+			return errorTable(L, "ERR Write commands are not allowed from read-only scripts.")
+		}
+		e.mu.Lock()
+		exec.wrote = true
+		e.mu.Unlock()
+	}
+
+	client := exec.client
+	client.Cmd = cmd
+	err := cmd.Execute(args[1:], exec.ctx, client)
+	if cmdErr, ok := command.AsCommandError(err); ok {
+		client.Writer.Flush()
+		exec.buf.Reset()
+		return errorTable(L, cmdErr.Msg)
+	}
+	if err == nil {
+		err = client.Writer.Flush()
+	}
+	if err != nil {
+		exec.buf.Reset()
+		return errorTable(L, "ERR "+err.Error())
+	}
+
+	values, err := (&protocol.RedisProtocolParser{}).Decode(exec.buf.Bytes())
+	exec.buf.Reset()
+	if err != nil || len(values) == 0 {
+		return errorTable(L, "ERR invalid reply from command")
+	}
+	return toLua(L, values[0])
+}
+
+// setResp implements redis.setresp, it chooses the protocol of the replies
+// that redis.call converts to Lua values
+func (e *Engine) setResp(L *lua.LState) int {
+	version := L.CheckInt(1)
+	if version != protocol.RESP2 && version != protocol.RESP3 {
+		L.RaiseError("RESP version must be 2 or 3.")
+	}
+	e.mu.Lock()
+	exec := e.running
+	e.mu.Unlock()
+	if exec == nil {
+		L.RaiseError("redis.setresp can only be called inside a script invocation")
+	}
+	exec.client.Writer.Protocol = version
+	return 0
+}
+
+func errorReply(L *lua.LState) int {
+	L.Push(errorTable(L, L.CheckString(1)))
+	return 1
+}
+
+func statusReply(L *lua.LState) int {
+	t := L.NewTable()
+	t.RawSetString("ok", lua.LString(L.CheckString(1)))
+	L.Push(t)
+	return 1
+}
+
+func sha1hex(L *lua.LState) int {
+	L.Push(lua.LString(SHA1(L.CheckString(1))))
+	return 1
+}
+
+func logMessage(L *lua.LState) int {
+	L.CheckInt(1)
+	parts := make([]string, 0, L.GetTop()-1)
+	for i := 2; i <= L.GetTop(); i++ {
+		parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+	}
+	log.Println("script:", strings.Join(parts, " "))
+	return 0
+}
+
+func errorTable(L *lua.LState, msg string) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString("err", lua.LString(msg))
+	return t
+}
+
+// formatNumber formats the numbers passed to redis.call like redis does, with
+// %.14g
+func formatNumber(n lua.LNumber) string {
+	return strconv.FormatFloat(float64(n), 'g', 14, 64)
+}
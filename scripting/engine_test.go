@@ -0,0 +1,257 @@
+package scripting
+
+import (
+	"bytes"
+	"context"
+	"redisgo/command"
+	"redisgo/protocol"
+	"testing"
+)
+
+// newTestEngine returns an engine whose table has SET and GET commands over a
+// map, and a DOUBLE command that replies with a RESP3 double
+func newTestEngine() (*Engine, map[string]string) {
+	data := map[string]string{}
+	table := command.NewTable(
+		&command.Command{Name: "set", Arity: 3, Flags: command.FlagWrite, Handler: command.HandlerFunc(func(args []string, ctx *context.Context, client *command.Client) error {
+			data[args[0]] = args[1]
+			return client.Writer.WriteOK()
+		})},
+		&command.Command{Name: "get", Arity: 2, Flags: command.FlagReadonly, Handler: command.HandlerFunc(func(args []string, ctx *context.Context, client *command.Client) error {
+			value, ok := data[args[0]]
+			if !ok {
+				return client.Writer.WriteNull()
+			}
+			return client.Writer.WriteBulk(value)
+		})},
+		&command.Command{Name: "double", Arity: 1, Handler: command.HandlerFunc(func(args []string, ctx *context.Context, client *command.Client) error {
+			return client.Writer.WriteDouble(1.5)
+		})},
+		&command.Command{Name: "multi", Arity: 1, Flags: command.FlagNoScript, Handler: &command.Multi{}},
+	)
+	return NewEngine(table), data
+}
+
+func eval(e *Engine, client *command.Client, script string, args ...string) error {
+	ctx := context.Background()
+	return (&Eval{Engine: e}).Execute(append([]string{script}, args...), &ctx, client)
+}
+
+func TestEval(t *testing.T) {
+	e, data := newTestEngine()
+	data["foo"] = "bar"
+
+	cases := []struct {
+		script   string
+		args     []string
+		expected string
+	}{
+		{"return 1", []string{"0"}, ":1\r\n"},
+		{"return 3.9", []string{"0"}, ":3\r\n"},
+		{"return 'hello'", []string{"0"}, "$5\r\nhello\r\n"},
+		{"return true", []string{"0"}, ":1\r\n"},
+		{"return false", []string{"0"}, "$-1\r\n"},
+		{"return nil", []string{"0"}, "$-1\r\n"},
+		{"return {1, 'a', {2}, nil, 3}", []string{"0"}, "*3\r\n:1\r\n$1\r\na\r\n*1\r\n:2\r\n"},
+		{"return {KEYS[1], KEYS[2], ARGV[1]}", []string{"2", "k1", "k2", "a1"}, "*3\r\n$2\r\nk1\r\n$2\r\nk2\r\n$2\r\na1\r\n"},
+		{"return redis.status_reply('FINE')", []string{"0"}, "+FINE\r\n"},
+		{"return {redis.error_reply('ERR nested')}", []string{"0"}, "*1\r\n-ERR nested\r\n"},
+		// CR and LF can't inject other replies
+		{"return redis.status_reply('a\\r\\n:7')", []string{"0"}, "+a  :7\r\n"},
+		{"return {redis.error_reply('ERR a\\r\\n:7')}", []string{"0"}, "*1\r\n-ERR a  :7\r\n"},
+		{"return redis.call('get', KEYS[1])", []string{"1", "foo"}, "$3\r\nbar\r\n"},
+		{"return redis.call('GET', 'missing') == false", []string{"0"}, ":1\r\n"},
+		{"return redis.call('set', KEYS[1], 42)", []string{"1", "num"}, "+OK\r\n"},
+		{"return redis.call('double')", []string{"0"}, "$3\r\n1.5\r\n"},
+		{"redis.setresp(3); return redis.call('double')['double'] * 2", []string{"0"}, ":3\r\n"},
+		{"return redis.pcall('nope')['err']", []string{"0"}, "$44\r\nERR Unknown Redis command called from script\r\n"},
+		{"return redis.sha1hex('')", []string{"0"}, "$40\r\nda39a3ee5e6b4b0d3255bfef95601890afd80709\r\n"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		client := &command.Client{Writer: protocol.NewWriter(&buf)}
+		if err := eval(e, client, c.script, c.args...); err != nil {
+			t.Errorf("%s: unexpected error %v", c.script, err)
+			continue
+		}
+		client.Writer.Flush()
+		if buf.String() != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.script, c.expected, buf.String())
+		}
+	}
+	if data["num"] != "42" {
+		t.Errorf("expected numbers to be passed as integers, got %q", data["num"])
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	e, _ := newTestEngine()
+
+	cases := []struct {
+		script   string
+		args     []string
+		expected string
+	}{
+		{"return 1", []string{"x"}, "ERR value is not an integer or out of range"},
+		{"return 1", []string{"-1"}, "ERR Number of keys can't be negative"},
+		{"return 1", []string{"2", "a"}, "ERR Number of keys can't be greater than number of args"},
+		{"return redis.call('nope')", []string{"0"}, "ERR Unknown Redis command called from script"},
+		{"return redis.call('get')", []string{"0"}, "ERR Wrong number of args calling Redis command from script"},
+		{"return redis.call('multi')", []string{"0"}, "ERR This Redis command is not allowed from script"},
+		{"return redis.call('get', {})", []string{"0"}, "ERR Lua redis lib command arguments must be strings or integers"},
+		{"return redis.error_reply('MYERR custom')", []string{"0"}, "MYERR custom"},
+		{"x = 1", []string{"0"}, "ERR Error running script: user_script:1: Script attempted to create global variable 'x'"},
+		{"return y", []string{"0"}, "ERR Error running script: user_script:1: Script attempted to access nonexistent global variable 'y'"},
+		{"return os.time()", []string{"0"}, "ERR Error running script: user_script:1: Script attempted to access nonexistent global variable 'os'"},
+		{"local t = {} t[1] = t return t", []string{"0"}, "ERR reached lua stack limit"},
+		{"local t = {} t[1] = {1, t} return t", []string{"0"}, "ERR reached lua stack limit"},
+		{"local t = {} for i = 1, 100000 do t = {t} end return t", []string{"0"}, "ERR reached lua stack limit"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		client := &command.Client{Writer: protocol.NewWriter(&buf)}
+		err := eval(e, client, c.script, c.args...)
+		cmdErr, ok := command.AsCommandError(err)
+		if !ok {
+			t.Errorf("%s: expected a command error, got %v", c.script, err)
+			continue
+		}
+		if cmdErr.Msg != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.script, c.expected, cmdErr.Msg)
+		}
+		client.Writer.Flush()
+		if buf.Len() != 0 {
+			t.Errorf("%s: expected no partial reply, got %q", c.script, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	client := &command.Client{Writer: protocol.NewWriter(&buf)}
+	err := eval(e, client, "return (", "0")
+	if cmdErr, ok := command.AsCommandError(err); !ok || !bytes.HasPrefix([]byte(cmdErr.Msg), []byte("ERR Error compiling script")) {
+		t.Errorf("expected a compile error, got %v", err)
+	}
+}
+
+func TestScriptCommandsRunAsTheCaller(t *testing.T) {
+	e, _ := newTestEngine()
+	authenticated := command.ClientFlag(1 << 20)
+	var seen []*command.Client
+	e.Table.Use(func(next command.CommandHandler) command.CommandHandler {
+		return command.HandlerFunc(func(args []string, ctx *context.Context, client *command.Client) error {
+			seen = append(seen, client)
+			return next.Execute(args, ctx, client)
+		})
+	})
+
+	var buf bytes.Buffer
+	client := &command.Client{ID: 7, Name: "app", Flags: authenticated, Writer: protocol.NewWriter(&buf)}
+	if err := eval(e, client, "return redis.call('get', 'foo')", "0"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(seen) != 1 {
+		t.Fatalf("expected the middleware to see the call, got %d calls", len(seen))
+	}
+	if c := seen[0]; c.ID != 7 || c.Name != "app" || !c.HasFlag(authenticated) || !c.HasFlag(command.ClientDenyBlocking) {
+		t.Errorf("expected the command to run with the state of the caller, got %+v", c)
+	}
+}
+
+func TestScriptsDontLeakState(t *testing.T) {
+	e, data := newTestEngine()
+	data["foo"] = "bar"
+	readOnly := "ERR Error running script: user_script:1: Attempt to modify a readonly table"
+
+	attempts := []struct {
+		script   string
+		expected string
+	}{
+		{"redis.call = function() return 'pwned' end", readOnly},
+		{"rawset(redis, 'call', function() return 'pwned' end)", readOnly},
+		{"rawset(_G, 'leak', 'v')", readOnly},
+		{"_G.redis = nil", readOnly},
+		{"string.rep = nil", readOnly},
+		{"table.insert = nil", readOnly},
+		{"setmetatable(_G, nil)", "ERR Error running script: user_script:1: cannot change a protected metatable"},
+		{"getmetatable('').__index.upper = nil", "ERR Error running script: user_script:1: attempt to index a non-table object(boolean) with key '__index'"},
+		{"setfenv(0, {})", "ERR Error running script: user_script:1: Script attempted to access nonexistent global variable 'setfenv'"},
+	}
+	for _, c := range attempts {
+		var buf bytes.Buffer
+		client := &command.Client{Writer: protocol.NewWriter(&buf)}
+		if err := eval(e, client, c.script, "0"); err == nil || err.Error() != c.expected {
+			t.Errorf("%s: expected %q, got %v", c.script, c.expected, err)
+		}
+	}
+
+	// the next scripts see the original globals and libraries
+	checks := []struct {
+		script   string
+		expected string
+	}{
+		{"return redis.call('get', 'foo')", "$3\r\nbar\r\n"},
+		{"return rawget(_G, 'leak') == nil", ":1\r\n"},
+		{"return rawget(_G, 'redis') ~= nil", ":1\r\n"},
+		{"return string.rep('a', 2) .. ('b'):upper()", "$3\r\naaB\r\n"},
+		{"local t = setmetatable({}, {__index = function() return 1 end}); return t.x", ":1\r\n"},
+	}
+	for _, c := range checks {
+		var buf bytes.Buffer
+		client := &command.Client{Writer: protocol.NewWriter(&buf)}
+		if err := eval(e, client, c.script, "0"); err != nil {
+			t.Errorf("%s: unexpected error %v", c.script, err)
+			continue
+		}
+		client.Writer.Flush()
+		if buf.String() != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.script, c.expected, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	client := &command.Client{Writer: protocol.NewWriter(&buf)}
+	eval(e, client, "return redis.call('set', KEYS[1], ARGV[1] / 3)", "1", "num", "1")
+	if data["num"] != "0.33333333333333" {
+		t.Errorf("expected numbers to be formatted with %%.14g, got %q", data["num"])
+	}
+}
+
+func TestScriptCache(t *testing.T) {
+	e, _ := newTestEngine()
+	ctx := context.Background()
+	var buf bytes.Buffer
+	client := &command.Client{Writer: protocol.NewWriter(&buf)}
+	script := &Script{Engine: e}
+	evalSha := &EvalSha{Engine: e}
+
+	sha := SHA1("return ARGV[1]")
+	if err := evalSha.Execute([]string{sha, "0"}, &ctx, client); err != ErrNoScript {
+		t.Fatalf("expected NOSCRIPT, got %v", err)
+	}
+	script.Execute([]string{"LOAD", "return ARGV[1]"}, &ctx, client)
+	script.Execute([]string{"EXISTS", sha, "ffff"}, &ctx, client)
+	if err := evalSha.Execute([]string{sha, "0", "hi"}, &ctx, client); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	script.Execute([]string{"FLUSH"}, &ctx, client)
+	script.Execute([]string{"EXISTS", sha}, &ctx, client)
+	if err := script.Execute([]string{"KILL"}, &ctx, client); err != ErrNotBusy {
+		t.Errorf("expected NOTBUSY, got %v", err)
+	}
+	client.Writer.Flush()
+
+	expected := "$40\r\n" + sha + "\r\n*2\r\n:1\r\n:0\r\n$2\r\nhi\r\n+OK\r\n*1\r\n:0\r\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestEvalKeys(t *testing.T) {
+	keys := EvalKeys([]string{"return 1", "2", "a", "b", "c"})
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("expected [a b], got %q", keys)
+	}
+	if keys := EvalKeys([]string{"return 1", "3", "a"}); keys != nil {
+		t.Errorf("expected no keys for an invalid numkeys, got %q", keys)
+	}
+}
@@ -0,0 +1,341 @@
+package scripting
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	command "redisgo/command"
+	protocol "redisgo/protocol"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// the code of a library must register its functions within this time
+const loadTimeout = 500 * time.Millisecond
+
+// dumpHeader starts the payloads of FUNCTION DUMP, it is followed by an array
+// with the code of the libraries and the CRC32 of the array
+const dumpHeader = "redisgo-functions-1\n"
+
+var functionFlags = []string{"no-writes", "allow-oom", "allow-stale", "no-cluster", "allow-cross-slot-keys"}
+
+var (
+	ErrFunctionNotFound = command.NewError("Function not found")
+	ErrLibraryNotFound  = command.NewError("Library not found")
+	ErrBadPayload       = command.NewError("payload version or checksum are wrong")
+)
+
+// Library is a group of functions loaded with FUNCTION LOAD, the first line
+// of Code declares its name like "#!lua name=mylib"
+type Library struct {
+	Name      string
+	Code      string
+	Functions []*Function
+}
+
+// Function is registered by the code of a library with
+// redis.register_function and called with FCALL
+type Function struct {
+	Name        string
+	Description string
+	Flags       []string
+	Library     *Library
+
+	callback *lua.LFunction
+}
+
+func (f *Function) HasFlag(flag string) bool {
+	return slices.Contains(f.Flags, flag)
+}
+
+// parseMetadata returns the library name declared in the first line of code
+func parseMetadata(code string) (string, error) {
+	line, _, _ := strings.Cut(code, "\n")
+	if !strings.HasPrefix(line, "#!") {
+		return "", command.NewError("Missing library metadata")
+	}
+	parts := strings.Fields(line[2:])
+	if len(parts) == 0 || !strings.EqualFold(parts[0], "lua") {
+		engine := ""
+		if len(parts) > 0 {
+			engine = parts[0]
+		}
+		return "", command.NewError("Engine '%s' not found", engine)
+	}
+
+	name := ""
+	for _, p := range parts[1:] {
+		key, value, ok := strings.Cut(p, "=")
+		if !ok || key != "name" {
+			return "", command.NewError("Invalid metadata value given: %s", p)
+		}
+		name = value
+	}
+	if name == "" {
+		return "", command.NewError("Library name was not given")
+	}
+	if !validName(name) {
+		return "", command.NewError("Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	return name, nil
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// compileLibrary runs the code of a library and returns it with the functions
+// it registered, the library isn't added to the engine
+func (e *Engine) compileLibrary(code string) (*Library, error) {
+	name, err := parseMetadata(code)
+	if err != nil {
+		return nil, err
+	}
+	// the metadata line is commented out, so the line numbers of the errors
+	// match the code
+	proto, err := compile("--"+code, "user_function")
+	if err != nil {
+		return nil, command.NewError("Error compiling function: %s", err)
+	}
+
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	lib := &Library{Name: name, Code: code}
+	e.loading = lib
+	defer func() { e.loading = nil }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+	L := e.L
+	L.SetContext(ctx)
+	L.Push(L.NewFunctionFromProto(proto))
+	err = L.PCall(0, 0, nil)
+	L.RemoveContext()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, command.NewError("FUNCTION LOAD timeout")
+		}
+		return nil, scriptError(err, "Error registering functions")
+	}
+	if len(lib.Functions) == 0 {
+		return nil, command.NewError("No functions registered")
+	}
+	return lib, nil
+}
+
+// registerFunction implements redis.register_function, which takes the
+// name and the callback or a table with the function_name, callback, flags
+// and description fields
+func (e *Engine) registerFunction(L *lua.LState) int {
+	lib := e.loading
+	if lib == nil {
+		raise(L, "ERR redis.register_function can only be called on FUNCTION LOAD command")
+	}
+
+	f := &Function{Library: lib}
+	if t, ok := L.Get(1).(*lua.LTable); ok && L.GetTop() == 1 {
+		f.Name = lua.LVAsString(t.RawGetString("function_name"))
+		f.callback, _ = t.RawGetString("callback").(*lua.LFunction)
+		f.Description = lua.LVAsString(t.RawGetString("description"))
+		if flags, ok := t.RawGetString("flags").(*lua.LTable); ok {
+			for i := 1; i <= flags.Len(); i++ {
+				flag := lua.LVAsString(flags.RawGetInt(i))
+				if !slices.Contains(functionFlags, flag) {
+					raise(L, "ERR unknown flag given")
+				}
+				f.Flags = append(f.Flags, flag)
+			}
+		}
+	} else if L.GetTop() == 2 {
+		f.Name = lua.LVAsString(L.Get(1))
+		f.callback, _ = L.Get(2).(*lua.LFunction)
+	} else {
+		raise(L, "ERR wrong number of arguments to redis.register_function")
+	}
+
+	if f.callback == nil {
+		raise(L, "ERR callback argument given to redis.register_function must be a function")
+	}
+	if !validName(f.Name) {
+		raise(L, "ERR Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	for _, other := range lib.Functions {
+		if other.Name == f.Name {
+			raise(L, "ERR Function already exists in the library")
+		}
+	}
+	lib.Functions = append(lib.Functions, f)
+	return 0
+}
+
+// raise stops the script with an error that is sent to the client as it is
+func raise(L *lua.LState, msg string) {
+	L.Error(errorTable(L, msg), 0)
+}
+
+// LoadLibrary loads a library and returns its name, replace allows an existing
+// library with the same name to be replaced
+func (e *Engine) LoadLibrary(code string, replace bool) (string, error) {
+	lib, err := e.compileLibrary(code)
+	if err != nil {
+		return "", err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.addLibraries([]*Library{lib}, replace); err != nil {
+		return "", err
+	}
+	return lib.Name, nil
+}
+
+// addLibraries adds all the libraries, or none of them if any has the name of
+// a loaded library or a function of another library. e.mu must be held.
+func (e *Engine) addLibraries(libs []*Library, replace bool) error {
+	for _, lib := range libs {
+		if _, ok := e.libraries[lib.Name]; ok && !replace {
+			return command.NewError("Library '%s' already exists", lib.Name)
+		}
+		for _, f := range lib.Functions {
+			if other, ok := e.functions[f.Name]; ok && other.Library.Name != lib.Name {
+				return command.NewError("Function %s already exists", f.Name)
+			}
+		}
+	}
+	for _, lib := range libs {
+		e.removeLibrary(lib.Name)
+		e.libraries[lib.Name] = lib
+		for _, f := range lib.Functions {
+			e.functions[f.Name] = f
+		}
+	}
+	return nil
+}
+
+func (e *Engine) removeLibrary(name string) bool {
+	lib, ok := e.libraries[name]
+	if !ok {
+		return false
+	}
+	for _, f := range lib.Functions {
+		delete(e.functions, f.Name)
+	}
+	delete(e.libraries, name)
+	return true
+}
+
+// DeleteLibrary removes a library and its functions
+func (e *Engine) DeleteLibrary(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.removeLibrary(name) {
+		return ErrLibraryNotFound
+	}
+	return nil
+}
+
+// FlushLibraries removes all the libraries
+func (e *Engine) FlushLibraries() {
+	e.mu.Lock()
+	e.libraries = make(map[string]*Library)
+	e.functions = make(map[string]*Function)
+	e.mu.Unlock()
+}
+
+// Libraries returns the loaded libraries sorted by name
+func (e *Engine) Libraries() []*Library {
+	e.mu.Lock()
+	libs := make([]*Library, 0, len(e.libraries))
+	for _, lib := range e.libraries {
+		libs = append(libs, lib)
+	}
+	e.mu.Unlock()
+	sort.Slice(libs, func(i, j int) bool {
+		return libs[i].Name < libs[j].Name
+	})
+	return libs
+}
+
+// Dump serializes the loaded libraries, the payload is loaded back with
+// Restore. It is how the libraries are stored and sent to replicas.
+func (e *Engine) Dump() string {
+	libs := e.Libraries()
+	codes := make([]string, len(libs))
+	for i, lib := range libs {
+		codes[i] = lib.Code
+	}
+	body := (&protocol.RedisProtocolParser{}).EncodeAsArray(codes)
+	return fmt.Sprintf("%s%s%08x", dumpHeader, body, crc32.ChecksumIEEE([]byte(body)))
+}
+
+// Restore loads the libraries of a payload created by Dump. With the "flush"
+// policy the loaded libraries are removed first, with "append" the payload
+// can't contain a loaded library and with "replace" it replaces them.
+func (e *Engine) Restore(payload, policy string) error {
+	body, ok := strings.CutPrefix(payload, dumpHeader)
+	if !ok || len(body) < 8 {
+		return ErrBadPayload
+	}
+	body, checksum := body[:len(body)-8], body[len(body)-8:]
+	if fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(body))) != checksum {
+		return ErrBadPayload
+	}
+	// the payload can come from any client, the decoder enforces the
+	// protocol limits so a crafted one can't exhaust the stack or the memory
+	values, err := (&protocol.RedisProtocolParser{Limits: protocol.DefaultLimits}).Decode([]byte(body))
+	if err != nil || len(values) != 1 || values[0].Type != protocol.ARRAY {
+		return ErrBadPayload
+	}
+
+	libs := make([]*Library, 0, len(values[0].Array))
+	for _, v := range values[0].Array {
+		if v.Type != protocol.BULK_STRINGS || v.Null {
+			return ErrBadPayload
+		}
+		lib, err := e.compileLibrary(v.Str)
+		if err != nil {
+			return err
+		}
+		libs = append(libs, lib)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if policy == "flush" {
+		e.libraries = make(map[string]*Library)
+		e.functions = make(map[string]*Function)
+	}
+	return e.addLibraries(libs, policy == "replace")
+}
+
+// FCall runs a function and writes its result to client, readOnly is set by
+// FCALL_RO and only allows functions with the no-writes flag
+func (e *Engine) FCall(name string, keys, argv []string, readOnly bool, ctx *context.Context, client *command.Client) error {
+	e.mu.Lock()
+	f, ok := e.functions[name]
+	e.mu.Unlock()
+	if !ok {
+		return ErrFunctionNotFound
+	}
+	noWrites := f.HasFlag("no-writes")
+	if readOnly && !noWrites {
+		return command.NewError("Can not execute a script with write flag using *_ro command.")
+	}
+	return e.run(noWrites, true, ctx, client, func(L *lua.LState) int {
+		L.Push(f.callback)
+		L.Push(stringsTable(L, keys))
+		L.Push(stringsTable(L, argv))
+		return 2
+	})
+}
@@ -0,0 +1,230 @@
+package scripting
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"redisgo/command"
+	"redisgo/protocol"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testLibrary = `#!lua name=mylib
+local function echo(keys, args)
+	return {keys[1], args[1]}
+end
+redis.register_function('echo', echo)
+redis.register_function{
+	function_name = 'get',
+	callback = function(keys) return redis.call('GET', keys[1]) end,
+	flags = {'no-writes'},
+	description = 'reads a key',
+}
+redis.register_function('set', function(keys, args) return redis.call('SET', keys[1], args[1]) end)
+`
+
+func TestFunctionLoadAndCall(t *testing.T) {
+	e, data := newTestEngine()
+	ctx := context.Background()
+	var buf bytes.Buffer
+	client := &command.Client{Writer: protocol.NewWriter(&buf)}
+
+	name, err := e.LoadLibrary(testLibrary, false)
+	if err != nil || name != "mylib" {
+		t.Fatalf("expected mylib, got %q, %v", name, err)
+	}
+	if _, err := e.LoadLibrary(testLibrary, false); err == nil || err.Error() != "ERR Library 'mylib' already exists" {
+		t.Errorf("expected the library to exist, got %v", err)
+	}
+	if _, err := e.LoadLibrary(testLibrary, true); err != nil {
+		t.Errorf("unexpected error replacing the library: %v", err)
+	}
+
+	fcall := &FCall{Engine: e}
+	fcallRO := &FCall{Engine: e, ReadOnly: true}
+	fcall.Execute([]string{"echo", "1", "k", "a"}, &ctx, client)
+	fcall.Execute([]string{"set", "1", "foo", "bar"}, &ctx, client)
+	fcallRO.Execute([]string{"get", "1", "foo"}, &ctx, client)
+	client.Writer.Flush()
+	expected := "*2\r\n$1\r\nk\r\n$1\r\na\r\n+OK\r\n$3\r\nbar\r\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+	if data["foo"] != "bar" {
+		t.Errorf("expected the function to set foo, got %q", data["foo"])
+	}
+
+	if err := fcallRO.Execute([]string{"set", "1", "foo", "baz"}, &ctx, client); err == nil ||
+		err.Error() != "ERR Can not execute a script with write flag using *_ro command." {
+		t.Errorf("expected FCALL_RO to reject a write function, got %v", err)
+	}
+	if err := fcall.Execute([]string{"nope", "0"}, &ctx, client); err != ErrFunctionNotFound {
+		t.Errorf("expected function not found, got %v", err)
+	}
+
+	// a no-writes function can't call write commands
+	_, err = e.LoadLibrary("#!lua name=ro\nredis.register_function{function_name='ro_set', callback=function() return redis.call('SET', 'a', 'b') end, flags={'no-writes'}}", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := fcall.Execute([]string{"ro_set", "0"}, &ctx, client); err == nil ||
+		err.Error() != "ERR Write commands are not allowed from read-only scripts." {
+		t.Errorf("expected a read only error, got %v", err)
+	}
+}
+
+func TestFunctionLoadErrors(t *testing.T) {
+	e, _ := newTestEngine()
+	e.LoadLibrary(testLibrary, false)
+
+	cases := []struct {
+		code     string
+		expected string
+	}{
+		{"redis.register_function('f', function() end)", "ERR Missing library metadata"},
+		{"#!js name=lib\n", "ERR Engine 'js' not found"},
+		{"#!lua\n", "ERR Library name was not given"},
+		{"#!lua name=lib foo=bar\n", "ERR Invalid metadata value given: foo=bar"},
+		{"#!lua name=my-lib\n", "ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long"},
+		{"#!lua name=lib\nlocal x = 1", "ERR No functions registered"},
+		{"#!lua name=lib\nredis.register_function('echo', function() end)", "ERR Function echo already exists"},
+		{"#!lua name=lib\nredis.register_function('f', function() end)\nredis.register_function('f', function() end)", "ERR Function already exists in the library"},
+		{"#!lua name=lib\nredis.register_function{function_name='f', callback=function() end, flags={'bad'}}", "ERR unknown flag given"},
+		{"#!lua name=lib\nredis.call('GET', 'foo')", "ERR redis.call can only be called inside a script invocation"},
+		{"#!lua name=lib\nwhile true do end", "ERR FUNCTION LOAD timeout"},
+	}
+	for _, c := range cases {
+		_, err := e.LoadLibrary(c.code, false)
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("%q: expected %q, got %v", c.code, c.expected, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	client := &command.Client{Writer: protocol.NewWriter(&buf)}
+	err := eval(e, client, "redis.register_function('f', function() end)", "0")
+	if err == nil || err.Error() != "ERR redis.register_function can only be called on FUNCTION LOAD command" {
+		t.Errorf("expected register_function to fail in EVAL, got %v", err)
+	}
+}
+
+func TestFunctionDumpRestore(t *testing.T) {
+	e, _ := newTestEngine()
+	e.LoadLibrary(testLibrary, false)
+	payload := e.Dump()
+
+	other, _ := newTestEngine()
+	if err := other.Restore(payload, "append"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if libs := other.Libraries(); len(libs) != 1 || libs[0].Name != "mylib" || len(libs[0].Functions) != 3 {
+		t.Fatalf("expected mylib to be restored, got %v", libs)
+	}
+	if err := other.Restore(payload, "append"); err == nil || err.Error() != "ERR Library 'mylib' already exists" {
+		t.Errorf("expected APPEND to fail on collision, got %v", err)
+	}
+	if err := other.Restore(payload, "replace"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	other.LoadLibrary("#!lua name=extra\nredis.register_function('extra', function() end)", false)
+	if err := other.Restore(payload, "flush"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if libs := other.Libraries(); len(libs) != 1 {
+		t.Errorf("expected FLUSH to remove the other libraries, got %d", len(libs))
+	}
+
+	for _, bad := range []string{"", "garbage", payload[:len(payload)-1] + "x"} {
+		if err := other.Restore(bad, "append"); err != ErrBadPayload {
+			t.Errorf("%q: expected a bad payload error, got %v", bad, err)
+		}
+	}
+
+	// crafted payloads with a valid checksum, deeply nested arrays would
+	// overflow the stack of a recursive decoder
+	for _, body := range []string{
+		strings.Repeat("*1\r\n", 8_000_000) + "$0\r\n\r\n",
+		"*1\r\n*1\r\n$0\r\n\r\n",
+		"*1\r\n:1\r\n",
+	} {
+		bad := fmt.Sprintf("%s%s%08x", dumpHeader, body, crc32.ChecksumIEEE([]byte(body)))
+		if err := other.Restore(bad, "append"); err != ErrBadPayload {
+			t.Errorf("%.20q: expected a bad payload error, got %v", body, err)
+		}
+	}
+}
+
+func TestFunctionCommand(t *testing.T) {
+	e, _ := newTestEngine()
+	ctx := context.Background()
+	var buf bytes.Buffer
+	client := &command.Client{Writer: protocol.NewWriter(&buf)}
+	function := &FunctionCmd{Engine: e}
+
+	code := "#!lua name=lib\nredis.register_function{function_name='f', callback=function() end, flags={'no-writes'}}"
+	function.Execute([]string{"LOAD", code}, &ctx, client)
+	function.Execute([]string{"LIST", "LIBRARYNAME", "l*", "WITHCODE"}, &ctx, client)
+	function.Execute([]string{"LIST", "LIBRARYNAME", "x*"}, &ctx, client)
+	function.Execute([]string{"DELETE", "lib"}, &ctx, client)
+	client.Writer.Flush()
+
+	expected := "$3\r\nlib\r\n" +
+		"*1\r\n*8\r\n$12\r\nlibrary_name\r\n$3\r\nlib\r\n$6\r\nengine\r\n$3\r\nLUA\r\n" +
+		"$9\r\nfunctions\r\n*1\r\n*6\r\n$4\r\nname\r\n$1\r\nf\r\n$11\r\ndescription\r\n$-1\r\n$5\r\nflags\r\n*1\r\n$9\r\nno-writes\r\n" +
+		"$12\r\nlibrary_code\r\n$" + strconv.Itoa(len(code)) + "\r\n" + code + "\r\n" +
+		"*0\r\n+OK\r\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+	if err := function.Execute([]string{"DELETE", "lib"}, &ctx, client); err != ErrLibraryNotFound {
+		t.Errorf("expected library not found, got %v", err)
+	}
+	if err := function.Execute([]string{"RESTORE", "x", "BAD"}, &ctx, client); err == nil {
+		t.Errorf("expected an error for an invalid restore policy")
+	}
+}
+
+func TestKillMatchesTheRunningCode(t *testing.T) {
+	e, _ := newTestEngine()
+	ctx := context.Background()
+	e.LoadLibrary("#!lua name=loop\nredis.register_function('loop', function() while true do end end)", false)
+
+	kill := func(function bool, done chan error) {
+		t.Helper()
+		// the other kind of KILL doesn't stop the code
+		for {
+			err := e.Kill(!function)
+			if err == nil {
+				t.Fatalf("expected the other KILL to fail")
+			}
+			if err == ErrNotBusy && e.Kill(function) == nil {
+				break
+			}
+		}
+		expected := ErrKilled
+		if function {
+			expected = ErrFunctionKilled
+		}
+		if err := <-done; err != expected {
+			t.Errorf("expected %v, got %v", expected, err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var buf bytes.Buffer
+		done <- eval(e, &command.Client{Writer: protocol.NewWriter(&buf)}, "while true do end", "0")
+	}()
+	kill(false, done)
+
+	go func() {
+		var buf bytes.Buffer
+		client := &command.Client{Writer: protocol.NewWriter(&buf)}
+		done <- (&FCall{Engine: e}).Execute([]string{"loop", "0"}, &ctx, client)
+	}()
+	kill(true, done)
+}
@@ -0,0 +1,474 @@
+package server
+
+import (
+	command "redisgo/command"
+	network "redisgo/network"
+	protocol "redisgo/protocol"
+	scripting "redisgo/scripting"
+	storage "redisgo/storage"
+	"time"
+)
+
+// builtinCommands returns the table with the commands implemented by the server
+func builtinCommands(storage *storage.Storage, replicaChan chan []byte, info command.InfoProvider) *command.Table {
+	commands := command.NewTable(
+		&command.Command{
+			Name:    protocol.PING,
+			Arity:   -1,
+			Flags:   command.FlagFast,
+			Handler: &command.PingHandler{},
+			Group:   "connection",
+			Since:   "1.0.0",
+			Summary: "Returns the server's liveliness response.",
+		},
+		&command.Command{
+			Name:    protocol.ECHO,
+			Arity:   2,
+			Flags:   command.FlagFast,
+			Handler: &command.EchoHandler{},
+			Group:   "connection",
+			Since:   "1.0.0",
+			Summary: "Returns the given string.",
+		},
+		&command.Command{
+			Name:    protocol.HELLO,
+			Arity:   -1,
+			Flags:   command.FlagFast,
+			Handler: &command.Hello{Role: network.MASTER},
+			Group:   "connection",
+			Since:   "6.0.0",
+			Summary: "Handshakes with the Redis server.",
+		},
+		&command.Command{
+			Name:    protocol.INFO,
+			Arity:   -1,
+			Handler: &command.Info{Provider: info},
+			Group:   "server",
+			Since:   "1.0.0",
+			Summary: "Returns information and statistics about the server.",
+		},
+		&command.Command{
+			Name:     protocol.GET,
+			Arity:    2,
+			Flags:    command.FlagReadonly | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.GetHandler{Storage: storage},
+			Group:   "string",
+			Since:   "1.0.0",
+			Summary: "Returns the string value of a key.",
+		},
+		&command.Command{
+			Name:     protocol.SET,
+			Arity:    -3,
+			Flags:    command.FlagWrite,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.SetHandler{Storage: storage, ReplicaChan: replicaChan},
+			Group:   "string",
+			Since:   "1.0.0",
+			Summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.",
+		},
+		&command.Command{
+			Name:     protocol.RPUSH,
+			Arity:    -3,
+			Flags:    command.FlagWrite | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.RPush{Storage: storage},
+			Group:   "list",
+			Since:   "1.0.0",
+			Summary: "Appends one or more elements to a list. Creates the key if it doesn't exist.",
+		},
+		&command.Command{
+			Name:     protocol.LPUSH,
+			Arity:    -3,
+			Flags:    command.FlagWrite | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.LPush{Storage: storage},
+			Group:   "list",
+			Since:   "1.0.0",
+			Summary: "Prepends one or more elements to a list. Creates the key if it doesn't exist.",
+		},
+		&command.Command{
+			Name:     protocol.LRANGE,
+			Arity:    4,
+			Flags:    command.FlagReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.LRange{Storage: storage},
+			Group:   "list",
+			Since:   "1.0.0",
+			Summary: "Returns a range of elements from a list.",
+		},
+		&command.Command{
+			Name:     protocol.LLEN,
+			Arity:    2,
+			Flags:    command.FlagReadonly | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.LLEN{Storage: storage},
+			Group:   "list",
+			Since:   "1.0.0",
+			Summary: "Returns the length of a list.",
+		},
+		&command.Command{
+			Name:     protocol.LPOP,
+			Arity:    -2,
+			Flags:    command.FlagWrite | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.LPOP{Storage: storage},
+			Group:   "list",
+			Since:   "1.0.0",
+			Summary: "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.",
+		},
+		&command.Command{
+			Name:     protocol.BLPOP,
+			Arity:    -3,
+			Flags:    command.FlagWrite | command.FlagBlocking,
+			FirstKey: 1, LastKey: -2, Step: 1,
+			Handler: &command.BLPOP{Storage: storage},
+			Group:   "list",
+			Since:   "2.0.0",
+			Summary: "Removes and returns the first element in a list. Blocks until an element is available otherwise.",
+		},
+		&command.Command{
+			Name:     protocol.TYPE,
+			Arity:    2,
+			Flags:    command.FlagReadonly | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.Type{Storage: storage},
+			Group:   "generic",
+			Since:   "1.0.0",
+			Summary: "Determines the type of value stored at a key.",
+		},
+		&command.Command{
+			Name:     protocol.EXPIRE,
+			Arity:    -3,
+			Flags:    command.FlagWrite | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.Expire{Storage: storage, Unit: time.Second},
+			Group:   "generic",
+			Since:   "1.0.0",
+			Summary: "Sets the expiration time of a key in seconds.",
+		},
+		&command.Command{
+			Name:     protocol.PEXPIRE,
+			Arity:    -3,
+			Flags:    command.FlagWrite | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.Expire{Storage: storage, Unit: time.Millisecond},
+			Group:   "generic",
+			Since:   "2.6.0",
+			Summary: "Sets the expiration time of a key in milliseconds.",
+		},
+		&command.Command{
+			Name:     protocol.EXPIREAT,
+			Arity:    -3,
+			Flags:    command.FlagWrite | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.Expire{Storage: storage, Unit: time.Second, Absolute: true},
+			Group:   "generic",
+			Since:   "1.2.0",
+			Summary: "Sets the expiration time of a key to a Unix timestamp.",
+		},
+		&command.Command{
+			Name:     protocol.PEXPIREAT,
+			Arity:    -3,
+			Flags:    command.FlagWrite | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.Expire{Storage: storage, Unit: time.Millisecond, Absolute: true},
+			Group:   "generic",
+			Since:   "2.6.0",
+			Summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.",
+		},
+		&command.Command{
+			Name:     protocol.TTL,
+			Arity:    2,
+			Flags:    command.FlagReadonly | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.TTL{Storage: storage, Unit: time.Second},
+			Group:   "generic",
+			Since:   "1.0.0",
+			Summary: "Returns the expiration time in seconds of a key.",
+		},
+		&command.Command{
+			Name:     protocol.PTTL,
+			Arity:    2,
+			Flags:    command.FlagReadonly | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.TTL{Storage: storage, Unit: time.Millisecond},
+			Group:   "generic",
+			Since:   "2.6.0",
+			Summary: "Returns the expiration time in milliseconds of a key.",
+		},
+		&command.Command{
+			Name:     protocol.EXPIRETIME,
+			Arity:    2,
+			Flags:    command.FlagReadonly | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.TTL{Storage: storage, Unit: time.Second, Absolute: true},
+			Group:   "generic",
+			Since:   "7.0.0",
+			Summary: "Returns the expiration time of a key as a Unix timestamp.",
+		},
+		&command.Command{
+			Name:     protocol.PEXPIRETIME,
+			Arity:    2,
+			Flags:    command.FlagReadonly | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.TTL{Storage: storage, Unit: time.Millisecond, Absolute: true},
+			Group:   "generic",
+			Since:   "7.0.0",
+			Summary: "Returns the expiration time of a key as a Unix milliseconds timestamp.",
+		},
+		&command.Command{
+			Name:     protocol.PERSIST,
+			Arity:    2,
+			Flags:    command.FlagWrite | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.Persist{Storage: storage},
+			Group:   "generic",
+			Since:   "2.2.0",
+			Summary: "Removes the expiration time of a key.",
+		},
+		&command.Command{
+			Name:     protocol.DEL,
+			Arity:    -2,
+			Flags:    command.FlagWrite,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Handler: &command.Del{Storage: storage},
+			Group:   "generic",
+			Since:   "1.0.0",
+			Summary: "Deletes one or more keys.",
+		},
+		&command.Command{
+			Name:     protocol.UNLINK,
+			Arity:    -2,
+			Flags:    command.FlagWrite | command.FlagFast,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Handler: &command.Del{Storage: storage},
+			Group:   "generic",
+			Since:   "4.0.0",
+			Summary: "Asynchronously deletes one or more keys.",
+		},
+		&command.Command{
+			Name:     protocol.EXISTS,
+			Arity:    -2,
+			Flags:    command.FlagReadonly | command.FlagFast,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Handler: &command.Exists{Storage: storage},
+			Group:   "generic",
+			Since:   "1.0.0",
+			Summary: "Determines whether one or more keys exist.",
+		},
+		&command.Command{
+			Name:     protocol.TOUCH,
+			Arity:    -2,
+			Flags:    command.FlagReadonly | command.FlagFast,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Handler: &command.Exists{Storage: storage},
+			Group:   "generic",
+			Since:   "3.2.1",
+			Summary: "Returns the number of existing keys out of those specified after updating the time they were last accessed.",
+		},
+		&command.Command{
+			Name:     protocol.RENAME,
+			Arity:    3,
+			Flags:    command.FlagWrite,
+			FirstKey: 1, LastKey: 2, Step: 1,
+			Handler: &command.Rename{Storage: storage},
+			Group:   "generic",
+			Since:   "1.0.0",
+			Summary: "Renames a key and overwrites the destination.",
+		},
+		&command.Command{
+			Name:     protocol.RENAMENX,
+			Arity:    3,
+			Flags:    command.FlagWrite | command.FlagFast,
+			FirstKey: 1, LastKey: 2, Step: 1,
+			Handler: &command.Rename{Storage: storage, NX: true},
+			Group:   "generic",
+			Since:   "1.0.0",
+			Summary: "Renames a key only when the target key name doesn't exist.",
+		},
+		&command.Command{
+			Name:     protocol.COPY,
+			Arity:    -3,
+			Flags:    command.FlagWrite,
+			FirstKey: 1, LastKey: 2, Step: 1,
+			Handler: &command.Copy{Storage: storage},
+			Group:   "generic",
+			Since:   "6.2.0",
+			Summary: "Copies the value of a key to a new key.",
+		},
+		&command.Command{
+			Name:    protocol.RANDOMKEY,
+			Arity:   1,
+			Flags:   command.FlagReadonly,
+			Handler: &command.RandomKey{Storage: storage},
+			Group:   "generic",
+			Since:   "1.0.0",
+			Summary: "Returns a random key name from the database.",
+		},
+		&command.Command{
+			Name:    protocol.KEYS,
+			Arity:   2,
+			Flags:   command.FlagReadonly,
+			Handler: &command.Keys{Storage: storage},
+			Group:   "generic",
+			Since:   "1.0.0",
+			Summary: "Returns all key names that match a pattern.",
+		},
+		&command.Command{
+			Name:    protocol.SCAN,
+			Arity:   -2,
+			Flags:   command.FlagReadonly,
+			Handler: &command.Scan{Storage: storage},
+			Group:   "generic",
+			Since:   "2.8.0",
+			Summary: "Iterates over the key names in the database.",
+		},
+		&command.Command{
+			Name:     protocol.XADD,
+			Arity:    -5,
+			Flags:    command.FlagWrite | command.FlagFast,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.XAdd{Storage: storage},
+			Group:   "stream",
+			Since:   "5.0.0",
+			Summary: "Appends a new message to a stream. Creates the key if it doesn't exist.",
+		},
+		&command.Command{
+			Name:     protocol.XRANGE,
+			Arity:    -4,
+			Flags:    command.FlagReadonly,
+			FirstKey: 1, LastKey: 1, Step: 1,
+			Handler: &command.XRange{Storage: storage},
+			Group:   "stream",
+			Since:   "5.0.0",
+			Summary: "Returns the messages from a stream within a range of IDs.",
+		},
+		&command.Command{
+			Name:        protocol.XREAD,
+			Arity:       -4,
+			Flags:       command.FlagReadonly,
+			MovableKeys: command.XReadKeys,
+			Handler:     &command.XRead{Storage: storage},
+			Group:       "stream",
+			Since:       "5.0.0",
+			Summary:     "Returns messages from multiple streams with IDs greater than the ones requested.",
+		},
+		&command.Command{
+			Name:    protocol.MULTI,
+			Arity:   1,
+			Flags:   command.FlagNoScript | command.FlagFast,
+			Handler: &command.Multi{},
+			Group:   "transactions",
+			Since:   "1.2.0",
+			Summary: "Starts a transaction.",
+		},
+		&command.Command{
+			Name:    protocol.EXEC,
+			Arity:   1,
+			Flags:   command.FlagNoScript,
+			Handler: &command.Exec{},
+			Group:   "transactions",
+			Since:   "1.2.0",
+			Summary: "Executes all commands in a transaction.",
+		},
+		&command.Command{
+			Name:    protocol.DISCARD,
+			Arity:   1,
+			Flags:   command.FlagNoScript | command.FlagFast,
+			Handler: &command.Discard{},
+			Group:   "transactions",
+			Since:   "2.0.0",
+			Summary: "Discards a transaction.",
+		},
+		&command.Command{
+			Name:     protocol.WATCH,
+			Arity:    -2,
+			Flags:    command.FlagNoScript | command.FlagFast,
+			FirstKey: 1, LastKey: -1, Step: 1,
+			Handler: &command.Watch{Storage: storage},
+			Group:   "transactions",
+			Since:   "2.2.0",
+			Summary: "Monitors changes to keys to determine the execution of a transaction.",
+		},
+		&command.Command{
+			Name:    protocol.UNWATCH,
+			Arity:   1,
+			Flags:   command.FlagNoScript | command.FlagFast,
+			Handler: &command.Unwatch{},
+			Group:   "transactions",
+			Since:   "2.2.0",
+			Summary: "Forgets about watched keys of a transaction.",
+		},
+	)
+	commands.Register(&command.Command{
+		Name:    "command",
+		Arity:   -1,
+		Handler: &command.CommandCmd{Table: commands},
+		Group:   "server",
+		Since:   "2.8.13",
+		Summary: "Returns detailed information about all commands.",
+	})
+
+	engine := scripting.NewEngine(commands)
+	commands.Register(&command.Command{
+		Name:        protocol.EVAL,
+		Arity:       -3,
+		Flags:       command.FlagNoScript,
+		MovableKeys: scripting.EvalKeys,
+		Handler:     &scripting.Eval{Engine: engine},
+		Group:       "scripting",
+		Since:       "2.6.0",
+		Summary:     "Executes a server-side Lua script.",
+	})
+	commands.Register(&command.Command{
+		Name:        protocol.EVALSHA,
+		Arity:       -3,
+		Flags:       command.FlagNoScript,
+		MovableKeys: scripting.EvalKeys,
+		Handler:     &scripting.EvalSha{Engine: engine},
+		Group:       "scripting",
+		Since:       "2.6.0",
+		Summary:     "Executes a server-side Lua script by SHA1 digest.",
+	})
+	commands.Register(&command.Command{
+		Name:    protocol.SCRIPT,
+		Arity:   -2,
+		Flags:   command.FlagNoScript,
+		Handler: &scripting.Script{Engine: engine},
+		Group:   "scripting",
+		Since:   "2.6.0",
+		Summary: "Manages the server-side Lua scripts cache.",
+	})
+	commands.Register(&command.Command{
+		Name:        protocol.FCALL,
+		Arity:       -3,
+		Flags:       command.FlagNoScript,
+		MovableKeys: scripting.EvalKeys,
+		Handler:     &scripting.FCall{Engine: engine},
+		Group:       "scripting",
+		Since:       "7.0.0",
+		Summary:     "Invokes a function.",
+	})
+	commands.Register(&command.Command{
+		Name:        protocol.FCALL_RO,
+		Arity:       -3,
+		Flags:       command.FlagNoScript,
+		MovableKeys: scripting.EvalKeys,
+		Handler:     &scripting.FCall{Engine: engine, ReadOnly: true},
+		Group:       "scripting",
+		Since:       "7.0.0",
+		Summary:     "Invokes a read-only function.",
+	})
+	commands.Register(&command.Command{
+		Name:    protocol.FUNCTION,
+		Arity:   -2,
+		Flags:   command.FlagNoScript,
+		Handler: &scripting.FunctionCmd{Engine: engine},
+		Group:   "scripting",
+		Since:   "7.0.0",
+		Summary: "Manages the libraries of functions.",
+	})
+
+	return commands
+}
@@ -0,0 +1,144 @@
+// Package server runs a redisgo server inside another Go program, e.g. to
+// start an in-process server for integration tests:
+//
+//	srv := server.NewServer(server.Options{Addr: "127.0.0.1:0"})
+//	listener, _ := net.Listen("tcp", srv.Options().Addr)
+//	go srv.Serve(listener)
+//	defer srv.Shutdown(context.Background())
+package server
+
+import (
+	"context"
+	"net"
+	command "redisgo/command"
+	network "redisgo/network"
+	protocol "redisgo/protocol"
+	redis "redisgo/redis"
+	storage "redisgo/storage"
+	utils "redisgo/utils"
+	"strconv"
+	"time"
+)
+
+// activeExpireInterval is the time between the cycles that delete the
+// expired keys, redis runs 10 cycles per second by default
+const activeExpireInterval = 100 * time.Millisecond
+
+// ErrServerClosed is returned by ListenAndServe and Serve after Shutdown
+var ErrServerClosed = network.ErrServerClosed
+
+type Options struct {
+	// Addr is the TCP address to listen on, ":6379" if empty
+	Addr string
+	// Limits bounds the size of the requests, zero fields use the defaults
+	Limits protocol.Limits
+	// Propagator receives the write commands executed by the server, see
+	// command.Propagate
+	Propagator command.Propagator
+}
+
+type Server struct {
+	opts     Options
+	storage  *storage.Storage
+	commands *command.Table
+	info     *redis.InfoController
+	redis    *redis.Redis
+	cancel   context.CancelFunc
+}
+
+func NewServer(opts Options) *Server {
+	if opts.Addr == "" {
+		opts.Addr = ":6379"
+	}
+	_, port, _ := net.SplitHostPort(opts.Addr)
+
+	storage := storage.NewStorage()
+	instanceInfo := &redis.InstanceInfo{
+		Port: port,
+		Id:   utils.GenerateUUID(),
+	}
+	info := &redis.InfoController{
+		Role:         network.MASTER,
+		Port:         instanceInfo.Port,
+		MasterReplid: instanceInfo.Id,
+	}
+	commands := builtinCommands(storage, make(chan []byte), info)
+	if opts.Propagator != nil {
+		commands.Use(command.Propagate(opts.Propagator))
+	}
+	tcpServer, _ := network.CreateNewServer(port, network.MASTER, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		opts:     opts,
+		storage:  storage,
+		commands: commands,
+		info:     info,
+		cancel:   cancel,
+		redis: &redis.Redis{
+			Server:   tcpServer,
+			Commands: commands,
+			Parser:   &protocol.RedisProtocolParser{Limits: opts.Limits},
+			Info:     instanceInfo,
+			Ctx:      ctx,
+			Limits:   opts.Limits,
+		},
+	}
+}
+
+func (s *Server) Options() Options {
+	return s.opts
+}
+
+// Storage returns the data of the server, custom commands use Storage.Do to
+// read and write it atomically
+func (s *Server) Storage() *storage.Storage {
+	return s.storage
+}
+
+// RegisterCommand adds a command to the server, the name must not be used by
+// another command. It must be called before the server starts serving.
+func (s *Server) RegisterCommand(cmd *command.Command) {
+	s.commands.Register(cmd)
+}
+
+// Use adds middlewares that run around every command, see command.Middleware.
+// It must be called before the server starts serving.
+func (s *Server) Use(middlewares ...command.Middleware) {
+	s.redis.Use(middlewares...)
+}
+
+// ListenAndServe listens on the TCP address of the options and serves the
+// connections until Shutdown is called
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.opts.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve handles the connections accepted by listener until Shutdown is
+// called, then it returns ErrServerClosed
+func (s *Server) Serve(listener net.Listener) error {
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		port := addr.AddrPort().Port()
+		s.redis.Info.Port = strconv.Itoa(int(port))
+		s.info.Port = s.redis.Info.Port
+	}
+	// the expired keys nobody reads are deleted until the server shuts down
+	go s.storage.ActiveExpire(s.redis.Ctx, activeExpireInterval)
+	return s.redis.Serve(listener)
+}
+
+// Addr returns the address the server is listening on, or nil if it is not serving yet
+func (s *Server) Addr() net.Addr {
+	return s.redis.Server.Addr()
+}
+
+// Shutdown stops accepting connections, closes the open ones and waits for
+// their commands to finish or for ctx to be done
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.cancel()
+	return s.redis.Shutdown(ctx)
+}
@@ -0,0 +1,1005 @@
+package server
+
+import (
+	"context"
+	"net"
+	"redisgo/command"
+	"redisgo/protocol"
+	"redisgo/storage"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer starts a server on a random port, setup runs before it starts serving
+func startServer(t *testing.T, setup ...func(srv *Server)) *Server {
+	t.Helper()
+	srv := NewServer(Options{Addr: "127.0.0.1:0"})
+	for _, f := range setup {
+		f(srv)
+	}
+	listener, err := net.Listen("tcp", srv.Options().Addr)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- srv.Serve(listener) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			t.Errorf("shutdown: %v", err)
+		}
+		if err := <-errChan; err != ErrServerClosed {
+			t.Errorf("expected ErrServerClosed, got %v", err)
+		}
+	})
+
+	for srv.Addr() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	return srv
+}
+
+// roundTrip sends the commands and returns one reply for each one
+func roundTrip(t *testing.T, conn net.Conn, commands ...[]string) []protocol.Value {
+	t.Helper()
+	parser := protocol.RedisProtocolParser{}
+	for _, c := range commands {
+		if _, err := conn.Write([]byte(parser.EncodeAsArray(c))); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := protocol.NewReader(conn)
+	replies := []protocol.Value{}
+	for range commands {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		values, err := parser.Decode(frame)
+		if err != nil {
+			t.Fatalf("decode %q: %v", frame, err)
+		}
+		replies = append(replies, values...)
+	}
+	return replies
+}
+
+func TestServerCommands(t *testing.T) {
+	srv := startServer(t)
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	replies := roundTrip(t, conn,
+		[]string{"PING"},
+		[]string{"SET", "foo", "bar"},
+		[]string{"GET", "foo"},
+		[]string{"RPUSH", "list", "a", "b"},
+		[]string{"LRANGE", "list", "0", "-1"},
+		[]string{"GET", "list"},
+		[]string{"LPUSH", "foo", "a"},
+		[]string{"TYPE", "list"},
+		[]string{"GET"},
+		[]string{"NOSUCHCOMMAND"},
+	)
+
+	expected := []protocol.Value{
+		protocol.SimpleString("PONG"),
+		protocol.SimpleString("OK"),
+		protocol.BulkString("bar"),
+		protocol.Integer(2),
+		protocol.BulkStringArray([]string{"a", "b"}),
+		protocol.Error("WRONGTYPE Operation against a key holding the wrong kind of value"),
+		protocol.Error("WRONGTYPE Operation against a key holding the wrong kind of value"),
+		protocol.SimpleString("list"),
+		protocol.Error("ERR wrong number of arguments for 'get' command"),
+		protocol.Error("ERR unknown command 'nosuchcommand'"),
+	}
+	parser := protocol.RedisProtocolParser{}
+	for i, e := range expected {
+		if string(parser.EncodeValue(replies[i], protocol.RESP2)) != string(parser.EncodeValue(e, protocol.RESP2)) {
+			t.Errorf("reply [%d]: expected %+v, got %+v", i, e, replies[i])
+		}
+	}
+}
+
+func TestServerInvalidRequestElements(t *testing.T) {
+	srv := startServer(t)
+	for request, expected := range map[string]string{
+		"*1\r\n$-1\r\n":                        "-ERR Protocol error: invalid bulk length\r\n",
+		"*1\r\n*0\r\n":                         "-ERR Protocol error: expected '$', got '*'\r\n",
+		"*2\r\n$3\r\nGET\r\n*1\r\n$1\r\na\r\n": "-ERR Protocol error: expected '$', got '*'\r\n",
+		"*0\r\n*1\r\n$4\r\nPING\r\n":           "+PONG\r\n",
+	} {
+		conn, err := net.Dial("tcp", srv.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		conn.Write([]byte(request))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		reply := make([]byte, 64)
+		n, _ := conn.Read(reply)
+		if string(reply[:n]) != expected {
+			t.Errorf("%q: expected %q, got %q", request, expected, reply[:n])
+		}
+		conn.Close()
+	}
+}
+
+func TestServerErrorsEchoingInput(t *testing.T) {
+	srv := startServer(t)
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// CR and LF echoed in an error don't start another reply
+	replies := roundTrip(t, conn,
+		[]string{"foo\r\n:42\r\nxy"},
+		[]string{"EXPIRE", "key", "10", "nx\r\n+OK"},
+		[]string{"PING"},
+	)
+	parser := protocol.RedisProtocolParser{}
+	expected := []string{
+		"-ERR unknown command 'foo  :42  xy'\r\n",
+		"-ERR Unsupported option nx  +OK\r\n",
+		"+PONG\r\n",
+	}
+	for i, e := range expected {
+		if got := string(parser.EncodeValue(replies[i], protocol.RESP2)); got != e {
+			t.Errorf("reply [%d]: expected %q, got %q", i, e, got)
+		}
+	}
+}
+
+func TestServerCustomCommand(t *testing.T) {
+	srv := NewServer(Options{Addr: "127.0.0.1:0"})
+	srv.RegisterCommand(&command.Command{
+		Name:  "double",
+		Arity: 2,
+		Flags: command.FlagFast,
+		Handler: command.HandlerFunc(func(args []string, ctx *context.Context, client *command.Client) error {
+			return client.Writer.WriteBulk(args[0] + args[0])
+		}),
+	})
+
+	listener, err := net.Listen("tcp", srv.Options().Addr)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	replies := roundTrip(t, conn, []string{"DOUBLE", "ab"}, []string{"COMMAND", "GETKEYS", "GET", "k"})
+	if replies[0].Str != "abab" {
+		t.Errorf("expected abab, got %+v", replies[0])
+	}
+	if len(replies[1].Array) != 1 || replies[1].Array[0].Str != "k" {
+		t.Errorf("expected [k], got %+v", replies[1])
+	}
+}
+
+func TestServerShutdownClosesConnections(t *testing.T) {
+	srv := NewServer(Options{Addr: "127.0.0.1:0"})
+	listener, err := net.Listen("tcp", srv.Options().Addr)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	errChan := make(chan error, 1)
+	go func() { errChan <- srv.Serve(listener) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn, []string{"PING"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := <-errChan; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("expected the connection to be closed")
+	}
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Errorf("expected the listener to be closed")
+	}
+}
+
+func TestServerShutdownWithBlockedClient(t *testing.T) {
+	srv := NewServer(Options{Addr: "127.0.0.1:0"})
+	listener, err := net.Listen("tcp", srv.Options().Addr)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	errChan := make(chan error, 1)
+	go func() { errChan <- srv.Serve(listener) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn, []string{"PING"})
+
+	// a zero timeout blocks until the server shuts down
+	parser := protocol.RedisProtocolParser{}
+	conn.Write([]byte(parser.EncodeAsArray([]string{"BLPOP", "queue", "0"})))
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the blocked client not to delay the shutdown, took %v", elapsed)
+	}
+	if err := <-errChan; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+}
+
+func TestServerPipelineBeforeBlockingCommand(t *testing.T) {
+	srv := startServer(t)
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// the reply to PING is sent before BLPOP starts waiting
+	parser := protocol.RedisProtocolParser{}
+	conn.Write([]byte(parser.EncodeAsArray([]string{"PING"}) + parser.EncodeAsArray([]string{"BLPOP", "queue", "5"})))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	reader := protocol.NewReader(conn)
+	frame, err := reader.ReadFrame()
+	if err != nil {
+		t.Fatalf("expected the PING reply before the BLPOP timeout: %v", err)
+	}
+	if string(frame) != "+PONG\r\n" {
+		t.Fatalf("unexpected reply %q", frame)
+	}
+
+	other, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer other.Close()
+	roundTrip(t, other, []string{"RPUSH", "queue", "job"})
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame, err = reader.ReadFrame()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(frame) != "*2\r\n$5\r\nqueue\r\n$3\r\njob\r\n" {
+		t.Errorf("unexpected BLPOP reply %q", frame)
+	}
+}
+
+func TestServerScriptWhileBlocked(t *testing.T) {
+	srv := startServer(t)
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	other, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer other.Close()
+
+	parser := protocol.RedisProtocolParser{}
+	conn.Write([]byte(parser.EncodeAsArray([]string{"BLPOP", "queue", "0"})))
+	time.Sleep(50 * time.Millisecond)
+
+	// the blocked client doesn't hold the gate while it waits, so the script
+	// can run and wake it up
+	replies := roundTrip(t, other, []string{"EVAL", "return redis.call('rpush', KEYS[1], 'job')", "1", "queue"})
+	if got := string(parser.EncodeValue(replies[0], protocol.RESP2)); got != ":1\r\n" {
+		t.Errorf("unexpected EVAL reply %q", got)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame, err := protocol.NewReader(conn).ReadFrame()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(frame) != "*2\r\n$5\r\nqueue\r\n$3\r\njob\r\n" {
+		t.Errorf("unexpected BLPOP reply %q", frame)
+	}
+}
+
+func TestServerAtomicCustomCommand(t *testing.T) {
+	propagated := make(chan []string, 10)
+	srv := NewServer(Options{
+		Addr:       "127.0.0.1:0",
+		Propagator: command.PropagatorFunc(func(args []string) { propagated <- args }),
+	})
+
+	// RESERVE key n decrements the stock stored in key if there are at least n items
+	srv.RegisterCommand(&command.Command{
+		Name:     "reserve",
+		Arity:    3,
+		Flags:    command.FlagWrite,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: command.HandlerFunc(func(args []string, ctx *context.Context, client *command.Client) error {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return command.ErrNotInteger
+			}
+			var left int
+			err = srv.Storage().Do(func(tx *storage.Tx) error {
+				value, _, err := tx.Get(args[0])
+				if err != nil {
+					return err
+				}
+				stock, _ := strconv.Atoi(value)
+				if stock < n {
+					return &command.CommandError{Msg: "NOSTOCK not enough items"}
+				}
+				left = stock - n
+				tx.Set(args[0], strconv.Itoa(left))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			client.Propagate("set", args[0], strconv.Itoa(left))
+			return client.Writer.WriteInt(int64(left))
+		}),
+	})
+
+	listener, err := net.Listen("tcp", srv.Options().Addr)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	srv.Storage().Set("stock", "10")
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Errorf("dial: %v", err)
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte("RESERVE stock 3\r\n"))
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if _, err := protocol.NewReader(conn).ReadFrame(); err != nil {
+				t.Errorf("read: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value, _, _ := srv.Storage().Get("stock"); value != "1" {
+		t.Errorf("expected 1 item left, got %s", value)
+	}
+	if len(propagated) != 3 {
+		t.Errorf("expected 3 propagated commands, got %d", len(propagated))
+	}
+	for range len(propagated) {
+		if args := <-propagated; args[0] != "set" || args[1] != "stock" {
+			t.Errorf("unexpected propagated command %q", args)
+		}
+	}
+}
+
+func TestServerTransactions(t *testing.T) {
+	srv := startServer(t)
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	parser := protocol.RedisProtocolParser{}
+	replies := roundTrip(t, conn,
+		[]string{"MULTI"},
+		[]string{"RPUSH", "list", "a"},
+		[]string{"LPOP", "list"},
+		[]string{"BLPOP", "list", "1"},
+		[]string{"EXEC"},
+		[]string{"MULTI"},
+		[]string{"SET", "foo"},
+		[]string{"SET", "foo", "bar"},
+		[]string{"EXEC"},
+		[]string{"GET", "foo"},
+		[]string{"MULTI"},
+		[]string{"SET", "foo", "bar"},
+		[]string{"DISCARD"},
+		[]string{"GET", "foo"},
+	)
+
+	expected := []string{
+		"+OK\r\n",
+		"+QUEUED\r\n",
+		"+QUEUED\r\n",
+		"+QUEUED\r\n",
+		"*3\r\n:1\r\n$1\r\na\r\n$-1\r\n",
+		"+OK\r\n",
+		"-ERR wrong number of arguments for 'set' command\r\n",
+		"+QUEUED\r\n",
+		"-EXECABORT Transaction discarded because of previous errors.\r\n",
+		"$-1\r\n",
+		"+OK\r\n",
+		"+QUEUED\r\n",
+		"+OK\r\n",
+		"$-1\r\n",
+	}
+	for i, e := range expected {
+		if got := string(parser.EncodeValue(replies[i], protocol.RESP2)); got != e {
+			t.Errorf("reply [%d]: expected %q, got %q", i, e, got)
+		}
+	}
+}
+
+func TestServerExecIsAtomic(t *testing.T) {
+	// every transaction reads the counter and writes it back incremented,
+	// the increments would be lost if transactions interleaved
+	srv := startServer(t, func(srv *Server) {
+		srv.RegisterCommand(&command.Command{
+			Name:  "incrslow",
+			Arity: 2,
+			Flags: command.FlagWrite,
+			Handler: command.HandlerFunc(func(args []string, ctx *context.Context, client *command.Client) error {
+				value, _, _ := srv.Storage().Get(args[0])
+				n, _ := strconv.Atoi(value)
+				time.Sleep(time.Millisecond)
+				srv.Storage().Set(args[0], strconv.Itoa(n+1))
+				return client.Writer.WriteOK()
+			}),
+		})
+	})
+	srv.Storage().Set("counter", "0")
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", srv.Addr().String())
+			if err != nil {
+				t.Errorf("dial: %v", err)
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte("MULTI\r\nINCRSLOW counter\r\nEXEC\r\n"))
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			reader := protocol.NewReader(conn)
+			for range 3 {
+				if _, err := reader.ReadFrame(); err != nil {
+					t.Errorf("read: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value, _, _ := srv.Storage().Get("counter"); value != "10" {
+		t.Errorf("expected 10, got %s", value)
+	}
+}
+
+func TestServerPropagationOrder(t *testing.T) {
+	// the last propagated write must be the last one applied, even if the
+	// commands take a while to return after they change the data
+	var mu sync.Mutex
+	last := ""
+	srv := startServer(t, func(srv *Server) {
+		srv.RegisterCommand(&command.Command{
+			Name:  "setslow",
+			Arity: 3,
+			Flags: command.FlagWrite,
+			Handler: command.HandlerFunc(func(args []string, ctx *context.Context, client *command.Client) error {
+				srv.Storage().Set(args[0], args[1])
+				n, _ := strconv.Atoi(args[1])
+				time.Sleep(time.Duration(10-n) * time.Millisecond)
+				return client.Writer.WriteOK()
+			}),
+		})
+	})
+	srv.Use(command.Propagate(command.PropagatorFunc(func(args []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		last = args[2]
+	})))
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", srv.Addr().String())
+			if err != nil {
+				t.Errorf("dial: %v", err)
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte("SETSLOW key " + strconv.Itoa(i) + "\r\n"))
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if _, err := protocol.NewReader(conn).ReadFrame(); err != nil {
+				t.Errorf("read: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if value, _, _ := srv.Storage().Get("key"); value != last {
+		t.Errorf("expected the last propagated value %q to be stored, got %q", last, value)
+	}
+}
+
+func TestServerPropagateTransaction(t *testing.T) {
+	propagated := make(chan []string, 20)
+	srv := startServer(t, func(srv *Server) {
+		srv.Use(command.Propagate(command.PropagatorFunc(func(args []string) { propagated <- args })))
+	})
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// the replies are received after the commands are propagated
+	expectPropagated := func(expected ...string) {
+		t.Helper()
+		for _, e := range expected {
+			select {
+			case args := <-propagated:
+				if got := strings.Join(args, " "); got != e {
+					t.Errorf("expected %q to be propagated, got %q", e, got)
+				}
+			default:
+				t.Fatalf("expected %q to be propagated", e)
+			}
+		}
+		if len(propagated) != 0 {
+			t.Errorf("unexpected propagated command %q", <-propagated)
+		}
+	}
+
+	roundTrip(t, conn, []string{"MULTI"}, []string{"SET", "a", "1"}, []string{"GET", "a"}, []string{"RPUSH", "list", "x"}, []string{"EXEC"})
+	expectPropagated("multi", "set a 1", "rpush list x", "exec")
+
+	// the writes of a script inside a transaction are part of it, in order
+	roundTrip(t, conn, []string{"MULTI"}, []string{"SET", "a", "1"}, []string{"EVAL", "redis.call('set', 'a', '2')", "0"}, []string{"EXEC"})
+	expectPropagated("multi", "set a 1", "set a 2", "exec")
+
+	// a script is propagated as a transaction, even if it fails after a write
+	roundTrip(t, conn, []string{"EVAL", "redis.call('set', 'a', '3') redis.call('rpush', 'list', 'y')", "0"})
+	expectPropagated("multi", "set a 3", "rpush list y", "exec")
+	roundTrip(t, conn, []string{"EVAL", "redis.call('set', 'a', '4') redis.call('rpush', 'a', 'y')", "0"})
+	expectPropagated("multi", "set a 4", "exec")
+	roundTrip(t, conn, []string{"EVAL", "return redis.call('get', 'a')", "0"})
+	expectPropagated()
+}
+
+func TestServerPropagateFunctionInTransaction(t *testing.T) {
+	propagated := make(chan []string, 20)
+	srv := startServer(t, func(srv *Server) {
+		srv.Use(command.Propagate(command.PropagatorFunc(func(args []string) { propagated <- args })))
+	})
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	library := "#!lua name=lib\nredis.register_function('setb', function(keys, args) return redis.call('set', keys[1], args[1]) end)"
+	roundTrip(t, conn, []string{"FUNCTION", "LOAD", library})
+	<-propagated
+
+	roundTrip(t, conn, []string{"MULTI"}, []string{"SET", "a", "1"}, []string{"FCALL", "setb", "1", "a", "2"}, []string{"SET", "b", "3"}, []string{"EXEC"})
+	for _, e := range []string{"multi", "set a 1", "set a 2", "set b 3", "exec"} {
+		select {
+		case args := <-propagated:
+			if got := strings.Join(args, " "); got != e {
+				t.Errorf("expected %q to be propagated, got %q", e, got)
+			}
+		default:
+			t.Fatalf("expected %q to be propagated", e)
+		}
+	}
+	if len(propagated) != 0 {
+		t.Errorf("unexpected propagated command %q", <-propagated)
+	}
+}
+
+func TestServerPropagateBlockingPop(t *testing.T) {
+	propagated := make(chan []string, 20)
+	srv := startServer(t, func(srv *Server) {
+		srv.Use(command.Propagate(command.PropagatorFunc(func(args []string) { propagated <- args })))
+	})
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	other, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer other.Close()
+
+	expectPropagated := func(expected ...string) {
+		t.Helper()
+		for _, e := range expected {
+			select {
+			case args := <-propagated:
+				if got := strings.Join(args, " "); got != e {
+					t.Errorf("expected %q to be propagated, got %q", e, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("expected %q to be propagated", e)
+			}
+		}
+		if len(propagated) != 0 {
+			t.Errorf("unexpected propagated command %q", <-propagated)
+		}
+	}
+
+	// a timeout doesn't change anything
+	roundTrip(t, conn, []string{"BLPOP", "q", "0.1"})
+	expectPropagated()
+
+	// a replica must never block, the pop is propagated as LPOP
+	roundTrip(t, conn, []string{"RPUSH", "q", "a"}, []string{"BLPOP", "q", "0"})
+	expectPropagated("rpush q a", "lpop q")
+
+	parser := protocol.RedisProtocolParser{}
+	conn.Write([]byte(parser.EncodeAsArray([]string{"BLPOP", "q", "0"})))
+	time.Sleep(50 * time.Millisecond)
+	roundTrip(t, other, []string{"RPUSH", "q", "b"})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := protocol.NewReader(conn).ReadFrame(); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	expectPropagated("rpush q b", "lpop q")
+}
+
+func TestServerBlockingPopKeys(t *testing.T) {
+	srv := startServer(t)
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	parser := protocol.RedisProtocolParser{}
+	check := func(replies []protocol.Value, expected ...string) {
+		t.Helper()
+		for i, e := range expected {
+			if got := string(parser.EncodeValue(replies[i], protocol.RESP2)); got != e {
+				t.Errorf("reply [%d]: expected %q, got %q", i, e, got)
+			}
+		}
+	}
+
+	// every argument but the timeout is a key
+	check(roundTrip(t, conn,
+		[]string{"BLPOP", "a"},
+		[]string{"BLPOP", "a", "b", "x"},
+		[]string{"BLPOP", "a", "-1"},
+		[]string{"COMMAND", "GETKEYS", "BLPOP", "a", "b", "0"},
+		[]string{"RPUSH", "b", "1", "2"},
+		[]string{"BLPOP", "a", "b", "0"},
+	),
+		"-ERR wrong number of arguments for 'blpop' command\r\n",
+		"-ERR timeout is not a float or out of range\r\n",
+		"-ERR timeout is negative\r\n",
+		"*2\r\n$1\r\na\r\n$1\r\nb\r\n",
+		":2\r\n",
+		"*2\r\n$1\r\nb\r\n$1\r\n1\r\n",
+	)
+
+	// a client waiting on several keys is woken by a push to any of them
+	other, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer other.Close()
+	conn.Write([]byte(parser.EncodeAsArray([]string{"BLPOP", "x", "y", "0"})))
+	time.Sleep(50 * time.Millisecond)
+	roundTrip(t, other, []string{"RPUSH", "y", "job"})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame, err := protocol.NewReader(conn).ReadFrame()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(frame) != "*2\r\n$1\r\ny\r\n$3\r\njob\r\n" {
+		t.Errorf("unexpected BLPOP reply %q", frame)
+	}
+	check(roundTrip(t, other, []string{"LLEN", "y"}), ":0\r\n")
+}
+
+func TestServerWatch(t *testing.T) {
+	srv := startServer(t)
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	other, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer other.Close()
+
+	parser := protocol.RedisProtocolParser{}
+	check := func(replies []protocol.Value, expected ...string) {
+		t.Helper()
+		for i, e := range expected {
+			if got := string(parser.EncodeValue(replies[i], protocol.RESP2)); got != e {
+				t.Errorf("reply [%d]: expected %q, got %q", i, e, got)
+			}
+		}
+	}
+
+	// the key is modified by another client, EXEC fails
+	check(roundTrip(t, conn, []string{"WATCH", "foo"}, []string{"MULTI"}, []string{"SET", "foo", "mine"}),
+		"+OK\r\n", "+OK\r\n", "+QUEUED\r\n")
+	check(roundTrip(t, other, []string{"SET", "foo", "theirs"}), "+OK\r\n")
+	check(roundTrip(t, conn, []string{"EXEC"}, []string{"GET", "foo"}), "*-1\r\n", "$6\r\ntheirs\r\n")
+
+	// EXEC unwatched the keys, the next transaction succeeds
+	check(roundTrip(t, other, []string{"SET", "foo", "again"}), "+OK\r\n")
+	check(roundTrip(t, conn, []string{"MULTI"}, []string{"SET", "foo", "mine"}, []string{"EXEC"}),
+		"+OK\r\n", "+QUEUED\r\n", "*1\r\n+OK\r\n")
+
+	// UNWATCH forgets the keys
+	check(roundTrip(t, conn, []string{"WATCH", "foo"}, []string{"UNWATCH"}), "+OK\r\n", "+OK\r\n")
+	check(roundTrip(t, other, []string{"SET", "foo", "theirs"}), "+OK\r\n")
+	check(roundTrip(t, conn, []string{"MULTI"}, []string{"GET", "foo"}, []string{"EXEC"}),
+		"+OK\r\n", "+QUEUED\r\n", "*1\r\n$6\r\ntheirs\r\n")
+
+	// WATCH is not allowed inside MULTI and doesn't abort the transaction
+	check(roundTrip(t, conn, []string{"MULTI"}, []string{"WATCH", "foo"}, []string{"EXEC"}),
+		"+OK\r\n", "-ERR WATCH inside MULTI is not allowed\r\n", "*0\r\n")
+}
+
+func TestServerScripting(t *testing.T) {
+	propagated := make(chan []string, 10)
+	srv := NewServer(Options{
+		Addr:       "127.0.0.1:0",
+		Propagator: command.PropagatorFunc(func(args []string) { propagated <- args }),
+	})
+	listener, err := net.Listen("tcp", srv.Options().Addr)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	parser := protocol.RedisProtocolParser{}
+	check := func(replies []protocol.Value, expected ...string) {
+		t.Helper()
+		for i, e := range expected {
+			if got := string(parser.EncodeValue(replies[i], protocol.RESP2)); got != e {
+				t.Errorf("reply [%d]: expected %q, got %q", i, e, got)
+			}
+		}
+	}
+
+	script := "redis.call('SET', KEYS[1], ARGV[1]); return redis.call('GET', KEYS[1])"
+	check(roundTrip(t, conn, []string{"EVAL", script, "1", "foo", "bar"}, []string{"GET", "foo"}),
+		"$3\r\nbar\r\n", "$3\r\nbar\r\n")
+
+	// the writes of the script are propagated inside MULTI/EXEC, the script
+	// itself is not
+	expectPropagated := func(expected ...[]string) {
+		t.Helper()
+		for _, e := range expected {
+			select {
+			case args := <-propagated:
+				if strings.Join(args, " ") != strings.Join(e, " ") {
+					t.Errorf("expected %q to be propagated, got %q", e, args)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("expected %q to be propagated", e)
+			}
+		}
+		if len(propagated) != 0 {
+			t.Errorf("unexpected propagated command %q", <-propagated)
+		}
+	}
+	expectPropagated([]string{"multi"}, []string{"set", "foo", "bar"}, []string{"exec"})
+
+	sha := roundTrip(t, conn, []string{"SCRIPT", "LOAD", script})[0].Str
+	check(roundTrip(t, conn, []string{"EVALSHA", sha, "1", "foo", "baz"}, []string{"COMMAND", "GETKEYS", "EVAL", script, "1", "foo", "bar"}),
+		"$3\r\nbaz\r\n", "*1\r\n$3\r\nfoo\r\n")
+	expectPropagated([]string{"multi"}, []string{"set", "foo", "baz"}, []string{"exec"})
+
+	// a table that contains itself is an error reply, the server keeps running
+	check(roundTrip(t, conn, []string{"EVAL", "local t = {} t[1] = t return t", "0"}, []string{"PING"}),
+		"-ERR reached lua stack limit\r\n", "+PONG\r\n")
+
+	// FUNCTION LOAD is propagated so replicas get the library
+	library := "#!lua name=lib\nredis.register_function('setget', function(keys, args)\n" + script + "\nend)"
+	library = strings.ReplaceAll(library, "KEYS", "keys")
+	library = strings.ReplaceAll(library, "ARGV", "args")
+	check(roundTrip(t, conn, []string{"FUNCTION", "LOAD", library}, []string{"FCALL", "setget", "1", "foo", "qux"}),
+		"$3\r\nlib\r\n", "$3\r\nqux\r\n")
+	expectPropagated([]string{"function", "LOAD", library}, []string{"multi"}, []string{"set", "foo", "qux"}, []string{"exec"})
+
+	// a script that didn't write can be killed from another connection
+	conn.Write([]byte(parser.EncodeAsArray([]string{"EVAL", "while true do end", "0"})))
+	other, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer other.Close()
+	for {
+		reply := roundTrip(t, other, []string{"SCRIPT", "KILL"})[0]
+		if reply.Str != "NOTBUSY No scripts in execution right now." {
+			check([]protocol.Value{reply}, "+OK\r\n")
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame, err := protocol.NewReader(conn).ReadFrame()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if expected := "-ERR Script killed by user with SCRIPT KILL...\r\n"; string(frame) != expected {
+		t.Errorf("expected %q, got %q", expected, frame)
+	}
+}
+
+func TestServerExpire(t *testing.T) {
+	propagated := make(chan []string, 10)
+	srv := NewServer(Options{
+		Addr:       "127.0.0.1:0",
+		Propagator: command.PropagatorFunc(func(args []string) { propagated <- args }),
+	})
+	listener, err := net.Listen("tcp", srv.Options().Addr)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	parser := protocol.RedisProtocolParser{}
+	check := func(replies []protocol.Value, expected ...string) {
+		t.Helper()
+		for i, e := range expected {
+			if got := string(parser.EncodeValue(replies[i], protocol.RESP2)); got != e {
+				t.Errorf("reply [%d]: expected %q, got %q", i, e, got)
+			}
+		}
+	}
+
+	check(roundTrip(t, conn,
+		[]string{"SET", "foo", "bar", "PX", "50"},
+		[]string{"SET", "foo", "bar", "EX", "0"},
+		[]string{"SET", "foo", "bar", "EX", "1", "PX", "1"},
+		[]string{"SET", "foo", "bar", "KEEP"},
+		[]string{"SET", "foo", "bar", "PXAT", "x"},
+		[]string{"SET", "foo", "bar", "EX", "9223372036854775807"},
+		[]string{"SET", "foo", "bar", "PX", "9223372036854775000"},
+		[]string{"GET", "foo"}),
+		"+OK\r\n", "-ERR invalid expire time in 'set' command\r\n", "-ERR syntax error\r\n",
+		"-ERR syntax error\r\n", "-ERR value is not an integer or out of range\r\n",
+		"-ERR invalid expire time in 'set' command\r\n", "-ERR invalid expire time in 'set' command\r\n", "$3\r\nbar\r\n")
+
+	// the relative expire time is propagated as an absolute time
+	args := <-propagated
+	if len(args) != 5 || strings.Join(args[:4], " ") != "set foo bar pxat" {
+		t.Fatalf("unexpected propagated command %q", args)
+	}
+	if at, _ := strconv.ParseInt(args[4], 10, 64); at < time.Now().UnixMilli() || at > time.Now().Add(50*time.Millisecond).UnixMilli() {
+		t.Errorf("unexpected expire time %s", args[4])
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	check(roundTrip(t, conn, []string{"GET", "foo"}), "$-1\r\n")
+}
+
+func TestServerKeyspace(t *testing.T) {
+	srv := NewServer(Options{Addr: "127.0.0.1:0"})
+	listener, err := net.Listen("tcp", srv.Options().Addr)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	parser := protocol.RedisProtocolParser{}
+	check := func(replies []protocol.Value, expected ...string) {
+		t.Helper()
+		for i, e := range expected {
+			if got := string(parser.EncodeValue(replies[i], protocol.RESP2)); got != e {
+				t.Errorf("reply [%d]: expected %q, got %q", i, e, got)
+			}
+		}
+	}
+
+	check(roundTrip(t, conn,
+		[]string{"SET", "foo", "bar"},
+		[]string{"RPUSH", "list", "a"},
+		[]string{"EXISTS", "foo", "list", "missing"},
+		[]string{"RENAME", "missing", "x"},
+		[]string{"RENAMENX", "foo", "list"},
+		[]string{"RENAME", "foo", "bar"},
+		[]string{"COPY", "bar", "bar"},
+		[]string{"COPY", "bar", "baz", "DB", "1"},
+		[]string{"COPY", "bar", "list", "DB", "0", "REPLACE"},
+		[]string{"GET", "list"},
+		[]string{"TOUCH", "bar", "baz"},
+		[]string{"DEL", "bar", "missing"},
+		[]string{"UNLINK", "list"},
+		[]string{"RANDOMKEY"}),
+		"+OK\r\n", ":1\r\n", ":2\r\n", "-ERR no such key\r\n", ":0\r\n", "+OK\r\n",
+		"-ERR source and destination objects are the same\r\n", "-ERR DB index is out of range\r\n", ":1\r\n",
+		"$3\r\nbar\r\n", ":1\r\n", ":1\r\n", ":1\r\n", "$-1\r\n")
+
+	check(roundTrip(t, conn,
+		[]string{"SET", "user:1", "a"},
+		[]string{"RPUSH", "user:2", "b"},
+		[]string{"KEYS", "user:[0-1]"},
+		[]string{"SCAN", "0", "MATCH", "user:*", "TYPE", "list", "COUNT", "100"},
+		[]string{"SCAN", "x"},
+		[]string{"SCAN", "0", "COUNT", "0"},
+		[]string{"SCAN", "0", "TYPE", "foo"}),
+		"+OK\r\n", ":1\r\n", "*1\r\n$6\r\nuser:1\r\n", "*2\r\n$1\r\n0\r\n*1\r\n$6\r\nuser:2\r\n",
+		"-ERR invalid cursor\r\n", "-ERR syntax error\r\n", "-ERR unknown type name 'foo'\r\n")
+
+	// a huge COUNT doesn't end the iteration early
+	check(roundTrip(t, conn, []string{"SCAN", "0", "MATCH", "user:1", "COUNT", "1000000000000000000"}),
+		"*2\r\n$1\r\n0\r\n*1\r\n$6\r\nuser:1\r\n")
+}
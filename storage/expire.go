@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// the active expiration samples this many keys with a TTL per round, and
+// keeps sampling while more than a quarter of them were expired
+const (
+	activeExpireSample   = 20
+	activeExpireMaxRatio = 4
+)
+
+// isExpired reports whether key has a TTL that elapsed, the key is still in
+// the keyspace until expireIfNeeded deletes it
+func (s *Storage) isExpired(key string) bool {
+	at, ok := s.expires[key]
+	return ok && s.now().UnixMilli() > at
+}
+
+// expireIfNeeded deletes key if it expired, the caller must hold the write
+// lock. It returns true if the key was deleted.
+func (s *Storage) expireIfNeeded(key string) bool {
+	if !s.isExpired(key) {
+		return false
+	}
+	s.removeKey(key)
+	return true
+}
+
+// removeKey deletes key and its TTL, the caller must hold the write lock
+func (s *Storage) removeKey(key string) {
+	if _, ok := s.keyspace[key]; ok {
+		s.index.remove(key)
+	}
+	delete(s.keyspace, key)
+	delete(s.expires, key)
+	s.touch(key)
+}
+
+// rlock takes the read lock. If key expired it is deleted first, so reads
+// expire keys lazily like writes do.
+func (s *Storage) rlock(key string) {
+	s.mu.RLock()
+	if !s.isExpired(key) {
+		return
+	}
+	s.mu.RUnlock()
+	s.mu.Lock()
+	s.expireIfNeeded(key)
+	s.mu.Unlock()
+	s.mu.RLock()
+}
+
+// SetExpire sets the time at which key is deleted, it returns false if the
+// key doesn't exist
+func (s *Storage) SetExpire(key string, at time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setExpire(key, at)
+}
+
+func (s *Storage) setExpire(key string, at time.Time) bool {
+	s.expireIfNeeded(key)
+	if _, ok := s.keyspace[key]; !ok {
+		return false
+	}
+	s.expires[key] = at.UnixMilli()
+	s.touch(key)
+	return true
+}
+
+// ExpireTime returns the unix time in milliseconds at which key expires, -1
+// if the key has no TTL or -2 if it doesn't exist, like PEXPIRETIME
+func (s *Storage) ExpireTime(key string) int64 {
+	s.rlock(key)
+	defer s.mu.RUnlock()
+	return s.expireTime(key)
+}
+
+func (s *Storage) expireTime(key string) int64 {
+	if _, ok := s.keyspace[key]; !ok || s.isExpired(key) {
+		return -2
+	}
+	if at, ok := s.expires[key]; ok {
+		return at
+	}
+	return -1
+}
+
+// Persist removes the TTL of key, it returns false if the key doesn't exist
+// or has no TTL
+func (s *Storage) Persist(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.persist(key)
+}
+
+func (s *Storage) persist(key string) bool {
+	if s.expireTime(key) < 0 {
+		return false
+	}
+	delete(s.expires, key)
+	s.touch(key)
+	return true
+}
+
+// ActiveExpire deletes the expired keys in the background until ctx is done,
+// so keys that are never accessed again don't stay in memory. Every interval
+// it samples keys with a TTL and deletes the expired ones, like redis does.
+func (s *Storage) ActiveExpire(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// a cycle can't use more than a quarter of the interval
+			s.activeExpireCycle(time.Now().Add(interval / 4))
+		}
+	}
+}
+
+// activeExpireCycle samples keys with a TTL until few of them are expired or
+// the deadline is reached, it returns the number of deleted keys
+func (s *Storage) activeExpireCycle(deadline time.Time) int {
+	deleted := 0
+	for {
+		s.mu.Lock()
+		sampled, expired := 0, 0
+		// the iteration order of maps is random, the first keys are a sample
+		for key := range s.expires {
+			if sampled == activeExpireSample {
+				break
+			}
+			sampled++
+			if s.expireIfNeeded(key) {
+				expired++
+			}
+		}
+		s.mu.Unlock()
+
+		deleted += expired
+		if expired*activeExpireMaxRatio <= sampled || time.Now().After(deadline) {
+			return deleted
+		}
+	}
+}
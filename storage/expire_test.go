@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLazyExpire(t *testing.T) {
+	s := NewStorage()
+	now := time.UnixMilli(1000)
+	s.now = func() time.Time { return now }
+
+	s.Set("str", "value")
+	s.AppendValuesToList("list", "a", "b")
+	s.Set("persistent", "value")
+	if !s.SetExpire("str", now.Add(time.Second)) || !s.SetExpire("list", now.Add(time.Second)) {
+		t.Fatalf("expected the TTL to be set")
+	}
+	if s.SetExpire("missing", now.Add(time.Second)) {
+		t.Errorf("expected SetExpire to fail on a missing key")
+	}
+
+	// the key still exists at the expire time
+	now = now.Add(time.Second)
+	if _, ok, _ := s.Get("str"); !ok {
+		t.Errorf("expected str to exist until it expires")
+	}
+
+	now = now.Add(time.Millisecond)
+	if _, ok, _ := s.Get("str"); ok {
+		t.Errorf("expected str to be expired")
+	}
+	if typ := s.CheckType("list"); typ != "none" {
+		t.Errorf("expected list to be expired, got type %s", typ)
+	}
+	if _, ok, _ := s.Get("persistent"); !ok {
+		t.Errorf("expected a key without TTL to exist")
+	}
+	if len(s.keyspace) != 1 || len(s.expires) != 0 {
+		t.Errorf("expected the expired keys to be deleted, got %d keys and %d TTLs", len(s.keyspace), len(s.expires))
+	}
+
+	// a list pushed to an expired key starts empty
+	s.AppendValuesToList("list", "a")
+	s.SetExpire("list", now)
+	now = now.Add(time.Millisecond)
+	if n, _ := s.AppendValuesToList("list", "b"); n != 1 {
+		t.Errorf("expected a new list, got length %d", n)
+	}
+
+	// SET removes the TTL, and deleting an expired key touches its watchers
+	s.SetExpire("str", now)
+	s.Set("str", "value")
+	s.SetExpire("list", now)
+	watch := s.NewWatch()
+	watch.Add("list")
+	now = now.Add(time.Millisecond)
+	if _, ok, _ := s.Get("str"); !ok {
+		t.Errorf("expected SET to remove the TTL")
+	}
+	s.GetListLenght("list")
+	if !watch.Dirty() {
+		t.Errorf("expected the expired key to touch the watch")
+	}
+}
+
+func TestActiveExpire(t *testing.T) {
+	s := NewStorage()
+	now := time.UnixMilli(1000)
+	s.now = func() time.Time { return now }
+
+	for i := range 100 {
+		key := strconv.Itoa(i)
+		s.Set(key, "value")
+		if i%2 == 0 {
+			s.SetExpire(key, now)
+		} else {
+			s.SetExpire(key, now.Add(time.Hour))
+		}
+	}
+	now = now.Add(time.Millisecond)
+
+	// cycles stop when few sampled keys are expired, but all of them are
+	// deleted eventually
+	deleted := 0
+	for i := 0; i < 1000 && deleted < 50; i++ {
+		deleted += s.activeExpireCycle(time.Now().Add(time.Second))
+	}
+	if deleted != 50 || len(s.keyspace) != 50 || len(s.expires) != 50 {
+		t.Errorf("expected 50 keys to be deleted, got %d, %d keys left", deleted, len(s.keyspace))
+	}
+}
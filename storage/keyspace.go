@@ -0,0 +1,149 @@
+package storage
+
+import "errors"
+
+var (
+	// ErrNoSuchKey is returned by Rename when the source key doesn't exist
+	ErrNoSuchKey = errors.New("ERR no such key")
+	// ErrSameKey is returned by Copy when the source and destination are the
+	// same key
+	ErrSameKey = errors.New("ERR source and destination objects are the same")
+)
+
+// Delete removes the keys of any type and returns how many of them existed.
+// It serves both DEL and UNLINK: a deleted value is unreachable and the
+// garbage collector reclaims it in the background.
+func (s *Storage) Delete(keys ...string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := 0
+	for _, key := range keys {
+		if s.unlink(key) != nil {
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// unlink removes key from the keyspace and returns its value, or nil if the
+// key doesn't exist or expired
+func (s *Storage) unlink(key string) *Value {
+	if s.expireIfNeeded(key) {
+		return nil
+	}
+	v, ok := s.keyspace[key]
+	if !ok {
+		return nil
+	}
+	s.removeKey(key)
+	return v
+}
+
+// Exists returns how many of the keys exist, a key is counted every time it
+// is repeated
+func (s *Storage) Exists(keys ...string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, key := range keys {
+		if s.expireTime(key) != -2 {
+			n++
+		}
+	}
+	return n
+}
+
+// Rename moves the value and the TTL of src to dst. If replace is false and
+// dst exists nothing is done and it returns false.
+func (s *Storage) Rename(src, dst string, replace bool) (bool, error) {
+	s.mu.Lock()
+	s.expireIfNeeded(src)
+	s.expireIfNeeded(dst)
+	v, ok := s.keyspace[src]
+	if !ok {
+		s.mu.Unlock()
+		return false, ErrNoSuchKey
+	}
+	if _, exists := s.keyspace[dst]; exists && (!replace || src == dst) {
+		s.mu.Unlock()
+		// renaming a key to itself succeeds only if it can be replaced
+		return replace, nil
+	}
+
+	at, hasTTL := s.expires[src]
+	s.removeKey(src)
+	s.store(dst, v, at, hasTTL)
+	head, isList := listHead(v)
+	s.mu.Unlock()
+
+	if isList {
+		s.NotifyWaiter(dst, head)
+	}
+	return true, nil
+}
+
+// Copy stores a copy of the value and the TTL of src in dst. It returns false
+// if src doesn't exist, or if dst exists and replace is false.
+func (s *Storage) Copy(src, dst string, replace bool) (bool, error) {
+	if src == dst {
+		return false, ErrSameKey
+	}
+	s.mu.Lock()
+	s.expireIfNeeded(src)
+	s.expireIfNeeded(dst)
+	v, ok := s.keyspace[src]
+	if !ok {
+		s.mu.Unlock()
+		return false, nil
+	}
+	if _, exists := s.keyspace[dst]; exists && !replace {
+		s.mu.Unlock()
+		return false, nil
+	}
+
+	at, hasTTL := s.expires[src]
+	c := v.clone()
+	s.store(dst, c, at, hasTTL)
+	head, isList := listHead(c)
+	s.mu.Unlock()
+
+	if isList {
+		s.NotifyWaiter(dst, head)
+	}
+	return true, nil
+}
+
+// store replaces the value and the TTL of key, the caller must hold the
+// write lock
+func (s *Storage) store(key string, v *Value, at int64, hasTTL bool) {
+	s.setValue(key, v)
+	delete(s.expires, key)
+	if hasTTL {
+		s.expires[key] = at
+	}
+	s.touch(key)
+}
+
+// listHead returns the first element of v if it is a list, a client blocked
+// on a key is woken up when a list is moved to it
+func listHead(v *Value) (string, bool) {
+	if v.Type != TypeList || len(v.List) == 0 {
+		return "", false
+	}
+	return v.List[0], true
+}
+
+// RandomKey returns a random key that didn't expire, or false if there are
+// no keys
+func (s *Storage) RandomKey() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the iteration order of maps is random, the expired keys found on the
+	// way are deleted
+	for key := range s.keyspace {
+		if !s.expireIfNeeded(key) {
+			return key, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeleteAndExists(t *testing.T) {
+	s := NewStorage()
+	s.Set("str", "value")
+	s.AppendValuesToList("list", "a")
+	s.AddEntryStream("stream", map[string]string{"id": "1-1"})
+	for i := range 100 {
+		s.AppendValuesToList("big", strconv.Itoa(i))
+	}
+
+	if n := s.Exists("str", "str", "list", "missing"); n != 3 {
+		t.Errorf("expected repeated keys to be counted, got %d", n)
+	}
+	if n := s.Delete("str", "list", "missing"); n != 2 {
+		t.Errorf("expected 2 deleted keys, got %d", n)
+	}
+	if n := s.Delete("stream", "big", "str"); n != 2 {
+		t.Errorf("expected 2 deleted keys, got %d", n)
+	}
+	if n := s.Exists("str", "list", "stream", "big"); n != 0 {
+		t.Errorf("expected all the keys to be deleted, got %d", n)
+	}
+	if _, ok := s.RandomKey(); ok {
+		t.Errorf("expected no random key in an empty storage")
+	}
+
+	// an expired key doesn't exist
+	s.Set("str", "value")
+	s.SetExpire("str", time.Now().Add(-time.Second))
+	if n := s.Exists("str"); n != 0 {
+		t.Errorf("expected the expired key not to exist")
+	}
+	if _, ok := s.RandomKey(); ok {
+		t.Errorf("expected RandomKey to skip the expired key")
+	}
+}
+
+func TestRenameAndCopy(t *testing.T) {
+	s := NewStorage()
+	at := time.Now().Add(time.Hour)
+	s.AppendValuesToList("list", "a", "b")
+	s.SetExpire("list", at)
+	s.Set("str", "value")
+
+	if _, err := s.Rename("missing", "other", true); err != ErrNoSuchKey {
+		t.Errorf("expected ErrNoSuchKey, got %v", err)
+	}
+	if ok, _ := s.Rename("list", "str", false); ok {
+		t.Errorf("expected RENAMENX to fail when the destination exists")
+	}
+	if ok, _ := s.Rename("list", "list", true); !ok {
+		t.Errorf("expected renaming a key to itself to succeed")
+	}
+	if ok, _ := s.Rename("list", "renamed", true); !ok {
+		t.Fatalf("expected the key to be renamed")
+	}
+	if s.Exists("list") != 0 || s.ExpireTime("renamed") != at.UnixMilli() {
+		t.Errorf("expected the value and the TTL to be moved")
+	}
+
+	if _, err := s.Copy("str", "str", true); err != ErrSameKey {
+		t.Errorf("expected ErrSameKey, got %v", err)
+	}
+	if ok, _ := s.Copy("renamed", "str", false); ok {
+		t.Errorf("expected COPY to fail when the destination exists")
+	}
+	if ok, _ := s.Copy("renamed", "str", true); !ok {
+		t.Fatalf("expected the key to be copied")
+	}
+	// the copy doesn't share the list
+	s.AppendValuesToList("str", "c")
+	if n, _ := s.GetListLenght("renamed"); n != 2 {
+		t.Errorf("expected the source to be unchanged, got length %d", n)
+	}
+	if s.ExpireTime("str") != at.UnixMilli() {
+		t.Errorf("expected the TTL to be copied")
+	}
+	if key, ok := s.RandomKey(); !ok || (key != "str" && key != "renamed") {
+		t.Errorf("unexpected random key %q", key)
+	}
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"hash/maphash"
+	"math/bits"
+	utils "redisgo/utils"
+	"slices"
+)
+
+// minScanBuckets is the initial size of the scan index
+const minScanBuckets = 4
+
+// scanIndex keeps the keys in buckets by the hash of the key, so SCAN can
+// walk the keyspace in a stable order with a stateless cursor. The number of
+// buckets is a power of two that follows the number of keys.
+type scanIndex struct {
+	seed    maphash.Seed
+	buckets [][]string
+	n       int
+}
+
+func newScanIndex() *scanIndex {
+	return &scanIndex{seed: maphash.MakeSeed(), buckets: make([][]string, minScanBuckets)}
+}
+
+func (x *scanIndex) bucket(key string) uint64 {
+	return maphash.String(x.seed, key) & uint64(len(x.buckets)-1)
+}
+
+func (x *scanIndex) add(key string) {
+	b := x.bucket(key)
+	x.buckets[b] = append(x.buckets[b], key)
+	x.n++
+	if x.n > len(x.buckets) {
+		x.resize(len(x.buckets) * 2)
+	}
+}
+
+func (x *scanIndex) remove(key string) {
+	b := x.bucket(key)
+	i := slices.Index(x.buckets[b], key)
+	if i < 0 {
+		return
+	}
+	last := len(x.buckets[b]) - 1
+	x.buckets[b][i] = x.buckets[b][last]
+	x.buckets[b] = x.buckets[b][:last]
+	x.n--
+	// the index shrinks when less than 1/8 of the buckets are used
+	if len(x.buckets) > minScanBuckets && x.n < len(x.buckets)/8 {
+		x.resize(max(minScanBuckets, len(x.buckets)/4))
+	}
+}
+
+// resize moves the keys to a new set of buckets, it takes a time
+// proportional to the number of keys but it happens once every time the
+// number of keys doubles or shrinks
+func (x *scanIndex) resize(size int) {
+	old := x.buckets
+	x.buckets = make([][]string, size)
+	for _, keys := range old {
+		for _, key := range keys {
+			b := x.bucket(key)
+			x.buckets[b] = append(x.buckets[b], key)
+		}
+	}
+}
+
+// Keys returns the keys that match the glob-style pattern, see
+// utils.MatchGlob
+func (s *Storage) Keys(pattern string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := []string{}
+	for key := range s.keyspace {
+		if !s.isExpired(key) && utils.MatchGlob(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Scan returns about count keys of the buckets of the scan index starting
+// from cursor, and the cursor of the next call. The iteration starts and ends
+// with cursor 0. The keys that match pattern, if not empty, and hold a value
+// of type typ, if not TypeNone, are returned.
+//
+// Like in redis the cursor is incremented from its most significant bit, so
+// when the number of buckets changes between calls the buckets already
+// visited are mapped to positions below the cursor. Every key that exists
+// during the whole iteration is returned, some of them more than once.
+func (s *Storage) Scan(cursor uint64, count int, pattern string, typ ValueType) (uint64, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []string{}
+	mask := uint64(len(s.index.buckets) - 1)
+	// count is the number of keys to look at before the filters are applied,
+	// empty buckets count a little so a call doesn't walk the whole index.
+	// There is no point in visiting more buckets than the index has.
+	visits := len(s.index.buckets)
+	if count <= visits/10 {
+		visits = count * 10
+	}
+	scanned := 0
+	for visited := 0; visited < visits && scanned < count; visited++ {
+		bucket := s.index.buckets[cursor&mask]
+		scanned += len(bucket)
+		for _, key := range bucket {
+			if s.isExpired(key) || (pattern != "" && !utils.MatchGlob(pattern, key)) {
+				continue
+			}
+			if typ != TypeNone && s.keyspace[key].Type != typ {
+				continue
+			}
+			keys = append(keys, key)
+		}
+
+		// increment the reversed cursor
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+		if cursor == 0 {
+			break
+		}
+	}
+	return cursor, keys
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestKeys(t *testing.T) {
+	s := NewStorage()
+	for _, key := range []string{"user:1", "user:2", "user:10", "session:1"} {
+		s.Set(key, "value")
+	}
+	keys := s.Keys("user:?")
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"user:1", "user:2"}) {
+		t.Errorf("unexpected keys %q", keys)
+	}
+	if keys := s.Keys("*"); len(keys) != 4 {
+		t.Errorf("expected all the keys, got %q", keys)
+	}
+}
+
+// scanAll iterates over the keys calling modify after each call, it returns
+// how many times each key was returned
+func scanAll(s *Storage, count int, pattern string, typ ValueType, modify func(call int)) map[string]int {
+	seen := map[string]int{}
+	var cursor uint64
+	for call := 0; ; call++ {
+		var keys []string
+		cursor, keys = s.Scan(cursor, count, pattern, typ)
+		for _, key := range keys {
+			seen[key]++
+		}
+		if cursor == 0 {
+			return seen
+		}
+		modify(call)
+	}
+}
+
+func TestScanWhileResizing(t *testing.T) {
+	s := NewStorage()
+	for i := range 1000 {
+		s.Set("stable:"+strconv.Itoa(i), "value")
+	}
+
+	// the index grows while the keys are added and shrinks while they are
+	// deleted, the stable keys are returned anyway
+	for _, grow := range []bool{true, false} {
+		seen := scanAll(s, 10, "", TypeNone, func(call int) {
+			for i := range 50 {
+				key := "temp:" + strconv.Itoa(call*50+i)
+				if grow {
+					s.Set(key, "value")
+				} else {
+					s.Delete(key)
+				}
+			}
+		})
+		for i := range 1000 {
+			if seen["stable:"+strconv.Itoa(i)] == 0 {
+				t.Fatalf("grow %v: stable:%d was not returned", grow, i)
+			}
+		}
+	}
+	s.Delete(s.Keys("temp:*")...)
+	s.Delete(s.Keys("stable:*")[:990]...)
+	if len(s.index.buckets) > 64 {
+		t.Errorf("expected the index to shrink, got %d buckets", len(s.index.buckets))
+	}
+}
+
+func TestScanFilters(t *testing.T) {
+	s := NewStorage()
+	for i := range 20 {
+		s.Set("str:"+strconv.Itoa(i), "value")
+		s.AppendValuesToList("list:"+strconv.Itoa(i), "a")
+	}
+	noop := func(int) {}
+
+	seen := scanAll(s, 5, "str:1*", TypeNone, noop)
+	if len(seen) != 11 {
+		t.Errorf("expected the 11 keys that match, got %v", seen)
+	}
+	seen = scanAll(s, 5, "", TypeList, noop)
+	if len(seen) != 20 {
+		t.Errorf("expected the 20 lists, got %v", seen)
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("expected %s to be returned once without changes, got %d", key, n)
+		}
+	}
+
+	// a huge count returns all the keys in a single call
+	if cursor, keys := s.Scan(0, math.MaxInt, "", TypeNone); cursor != 0 || len(keys) != 40 {
+		t.Errorf("expected all the keys, got %d keys and cursor %d", len(keys), cursor)
+	}
+
+	if cursor, keys := NewStorage().Scan(0, 10, "", TypeNone); cursor != 0 || len(keys) != 0 {
+		t.Errorf("expected an empty iteration, got %d %q", cursor, keys)
+	}
+}
@@ -5,18 +5,21 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	utils "github.com/AntonyChR/go-utils"
 )
 
 func NewStorage() *Storage {
 	return &Storage{
-		keyValueData:          make(map[string]string),
-		keyListData:           make(map[string][]string),
-		streamData: 		   make(map[string][]map[string]string),
+		keyspace:              make(map[string]*Value),
+		expires:               make(map[string]int64),
+		index:                 newScanIndex(),
+		now:                   time.Now,
 		enabledRegisterOffset: false,
 		registerOffset:        0,
 		waiters: 			   make(map[string][]chan string),
+		watchers:              make(map[string]map[*Watch]struct{}),
 	}
 }
 
@@ -24,15 +27,24 @@ func NewStorage() *Storage {
 type Storage struct {
 	mu                    sync.RWMutex
 
-	//data
-	keyValueData          map[string]string
-	keyListData           map[string][]string
-	streamData            map[string][]map[string]string
+	// every key holds a single value of any type
+	keyspace              map[string]*Value
+
+	// unix time in milliseconds at which the keys with a TTL expire, see
+	// expire.go
+	expires               map[string]int64
+	now                   func() time.Time
+
+	// the keys by hash for SCAN, see scan.go
+	index                 *scanIndex
 
 	enabledRegisterOffset bool
 	registerOffset        int
 	waiters               map[string][]chan string
 	waitersMux            sync.Mutex
+
+	// clients watching each key, see Watch
+	watchers              map[string]map[*Watch]struct{}
 }
 
 func (s *Storage) RegisterWaiter(key string, ch chan string){
@@ -46,8 +58,11 @@ func (s *Storage) NotifyWaiter(key, value string){
 	defer s.waitersMux.Unlock()
 
 	if waiters, ok := s.waiters[key];ok && len(waiters) > 0  {
-		waiterChan := waiters[0]
-		waiterChan <- value
+		// a waiter of several keys can already have a notification
+		select {
+		case waiters[0] <- value:
+		default:
+		}
 		s.waiters[key] = waiters[1:]
 	}
 }
@@ -67,165 +82,243 @@ func (s *Storage) UnregisterWaiter(key string, ch chan string){
 	}
 }
 
-func (s *Storage) Get(key string) (value string, exists bool) {
-	s.mu.RLock()
+// lookup returns the value stored in key, or nil if the key doesn't exist or
+// expired. It fails with ErrWrongType if the value isn't of type typ.
+func (s *Storage) lookup(key string, typ ValueType) (*Value, error) {
+	v, ok := s.keyspace[key]
+	if !ok || s.isExpired(key) {
+		return nil, nil
+	}
+	if v.Type != typ {
+		return nil, ErrWrongType
+	}
+	return v, nil
+}
+
+func (s *Storage) Get(key string) (value string, exists bool, err error) {
+	s.rlock(key)
 	defer s.mu.RUnlock()
-	value, ok := s.keyValueData[key]
-	if !ok {
-		return "", false
+	return s.get(key)
+}
+
+func (s *Storage) get(key string) (value string, exists bool, err error) {
+	v, err := s.lookup(key, TypeString)
+	if v == nil {
+		return "", false, err
 	}
-	return value, true
+	return v.Str, true, nil
 }
 
 func (s *Storage) Set(key, value string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.keyValueData[key] = value
+	s.set(key, value)
+}
+
+// set stores a string in key, replacing the value of any type it held and
+// its TTL
+func (s *Storage) set(key, value string) {
+	s.setValue(key, &Value{Type: TypeString, Str: value})
+	delete(s.expires, key)
+	s.touch(key)
+}
+
+// setValue stores v in key, the caller must hold the write lock
+func (s *Storage) setValue(key string, v *Value) {
+	if _, ok := s.keyspace[key]; !ok {
+		s.index.add(key)
+	}
+	s.keyspace[key] = v
 }
 
 func (s *Storage) DeleteValue(key string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.keyValueData, key)
+	s.deleteValue(key)
+}
+
+// deleteValue removes key whatever the type of its value
+func (s *Storage) deleteValue(key string) {
+	if _, ok := s.keyspace[key]; ok {
+		s.removeKey(key)
+	}
 }
 
-func (s *Storage) AppendValuesToList(key string, values ...string) int {
+func (s *Storage) AppendValuesToList(key string, values ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.keyListData[key] = append(s.keyListData[key], values...)
-	return len(s.keyListData[key])
+	return s.appendValuesToList(key, values...)
+}
+
+func (s *Storage) appendValuesToList(key string, values ...string) (int, error) {
+	v, err := s.listForWrite(key)
+	if err != nil {
+		return 0, err
+	}
+	v.List = append(v.List, values...)
+	s.touch(key)
+	return len(v.List), nil
 }
 
-func (s *Storage) PrependValuesToList(key string, values ...string) int {
+func (s *Storage) PrependValuesToList(key string, values ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.keyListData[key] = append(values, s.keyListData[key]...)
-	return len(s.keyListData[key])
+	return s.prependValuesToList(key, values...)
 }
 
-func (s *Storage) GetSliceFromList(key string, start, stop int) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	list, ok := s.keyListData[key]
-	if !ok {
-		return []string{}
+func (s *Storage) prependValuesToList(key string, values ...string) (int, error) {
+	v, err := s.listForWrite(key)
+	if err != nil {
+		return 0, err
 	}
+	v.List = append(values, v.List...)
+	s.touch(key)
+	return len(v.List), nil
+}
 
-	start, stop, err := nomralizeListIndexes(start, stop, len(list))
+// listForWrite returns the list stored in key, creating it if the key
+// doesn't exist
+func (s *Storage) listForWrite(key string) (*Value, error) {
+	s.expireIfNeeded(key)
+	v, err := s.lookup(key, TypeList)
 	if err != nil {
-		return []string{}
+		return nil, err
 	}
-
-	return list[start : stop+1]
+	if v == nil {
+		v = &Value{Type: TypeList}
+		s.setValue(key, v)
+	}
+	return v, nil
 }
 
-func (s *Storage) GetListLenght(key string) int {
-	s.mu.RLock()
+func (s *Storage) GetSliceFromList(key string, start, stop int) ([]string, error) {
+	s.rlock(key)
 	defer s.mu.RUnlock()
-	if list, ok := s.keyListData[key]; ok {
-		return len(list)
-	}
-	return 0
+	return s.getSliceFromList(key, start, stop)
 }
 
-func (s *Storage) RemoveElementFromListByIndex(key string, index int) string {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *Storage) getSliceFromList(key string, start, stop int) ([]string, error) {
+	v, err := s.lookup(key, TypeList)
+	if v == nil {
+		return []string{}, err
+	}
 
-	list, ok := s.keyListData[key]
-	if !ok {
-		return ""
+	start, stop, err = nomralizeListIndexes(start, stop, len(v.List))
+	if err != nil {
+		return []string{}, nil
 	}
-	value := list[index]
 
-	s.keyListData[key] =append(list[:index], list[index+1:]...)
-	if len(s.keyListData[key]) == 0 {
-		delete(s.keyListData, key)
+	// the caller gets a copy, the list can be modified once the lock is released
+	return append([]string{}, v.List[start:stop+1]...), nil
+}
+
+func (s *Storage) GetListLenght(key string) (int, error) {
+	s.rlock(key)
+	defer s.mu.RUnlock()
+	return s.getListLength(key)
+}
+
+func (s *Storage) getListLength(key string) (int, error) {
+	v, err := s.lookup(key, TypeList)
+	if v == nil {
+		return 0, err
 	}
-	return value
+	return len(v.List), nil
 }
 
-func (s *Storage) RemoveFirstElementFromTheList(key string) string {
+func (s *Storage) RemoveElementFromListByIndex(key string, index int) (string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	list, ok := s.keyListData[key]
-	if !ok {
-		return ""
+	s.expireIfNeeded(key)
+	v, err := s.lookup(key, TypeList)
+	if v == nil {
+		return "", err
 	}
+	value := v.List[index]
 
-	value := list[0]
-
-	s.keyListData[key] = list[1:]
-	if len(s.keyListData[key]) == 0 {
-		delete(s.keyListData, key)
+	v.List = append(v.List[:index], v.List[index+1:]...)
+	s.touch(key)
+	if len(v.List) == 0 {
+		s.removeKey(key)
 	}
-	return value
+	return value, nil
+}
+
+func (s *Storage) RemoveFirstElementFromTheList(key string) (string, error) {
+	return s.RemoveElementFromListByIndex(key, 0)
 }
 
-func (s *Storage) RemoveFirstElementsFromTheList(key string, n int) []string {
+func (s *Storage) RemoveFirstElementsFromTheList(key string, n int) ([]string, error) {
 	return s.RemoveElementsFromListByRange(key, 0, n)
 }
 
-func (s *Storage) RemoveElementsFromListByRange(key string, start, stop int) []string {
+func (s *Storage) RemoveElementsFromListByRange(key string, start, stop int) ([]string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	list, ok := s.keyListData[key]
-	if !ok {
-		return []string{}
+	s.expireIfNeeded(key)
+	v, err := s.lookup(key, TypeList)
+	if v == nil {
+		return []string{}, err
 	}
 
-	start, stop, err := nomralizeListIndexes(start, stop, len(list))
+	start, stop, err = nomralizeListIndexes(start, stop, len(v.List))
 	if err != nil {
-		return []string{}
+		return []string{}, nil
 	}
 
 	removedElements := make([]string, stop-start+1)
-	copy(removedElements, list[start:stop+1])
-	s.keyListData[key] = append(list[:start], list[stop+1:]...)
+	copy(removedElements, v.List[start:stop+1])
+	v.List = append(v.List[:start], v.List[stop+1:]...)
+	s.touch(key)
 
-	if len(s.keyListData[key]) == 0 {
-		delete(s.keyListData, key)
+	if len(v.List) == 0 {
+		s.removeKey(key)
 	}
-	
-	return removedElements
+
+	return removedElements, nil
 }
 
 // streamData methods
-func (s *Storage) GetLastEntryStream(key string) (entry map[string]string, listLen int){
-	s.mu.RLock()
+func (s *Storage) GetLastEntryStream(key string) (entry map[string]string, listLen int, err error) {
+	s.rlock(key)
 	defer s.mu.RUnlock()
-	l, ok := s.streamData[key]
-	if !ok {
-		return nil, 0
+	v, err := s.lookup(key, TypeStream)
+	if v == nil {
+		return nil, 0, err
 	}
-	entry = l[len(l) - 1]
-	return entry, len(l)
+	entry = v.Stream[len(v.Stream)-1]
+	return entry, len(v.Stream), nil
 }
 
-func (s *Storage) AddEntryStream(key string, data map[string]string) error{
+func (s *Storage) AddEntryStream(key string, data map[string]string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	_, ok := s.streamData[key]; 
-	if !ok {
-		s.streamData[key] = []map[string]string{data}
+	s.expireIfNeeded(key)
+	v, err := s.lookup(key, TypeStream)
+	if err != nil {
+		return err
+	}
+	s.touch(key)
+	if v == nil {
+		s.setValue(key, &Value{Type: TypeStream, Stream: []map[string]string{data}})
 		return nil
 	}
 
-	s.streamData[key] = append(s.streamData[key], data)	
+	v.Stream = append(v.Stream, data)
 	return nil
 }
 
-func (s *Storage) GetStreamEntriesByRange(key string,startTimestamp, endTimestamp int64, startIndex, endIndex int) []map[string]string{
-	s.mu.RLock()
+func (s *Storage) GetStreamEntriesByRange(key string,startTimestamp, endTimestamp int64, startIndex, endIndex int) ([]map[string]string, error) {
+	s.rlock(key)
 	defer s.mu.RUnlock()
 	
-	list, ok := s.streamData[key]
-	if !ok {
-		return []map[string]string{}
+	v, err := s.lookup(key, TypeStream)
+	if v == nil {
+		return []map[string]string{}, err
 	}
+	list := v.Stream
 
 	cb := func(s map[string]string)bool{
 		timestamp, index := parseEntryId(s["id"])				
@@ -250,17 +343,18 @@ func (s *Storage) GetStreamEntriesByRange(key string,startTimestamp, endTimestam
 	
 	filteredData := utils.FilterPrealloc(list, cb)
 	resp := utils.DeepCopyArrMap(filteredData)
-	return resp
+	return resp, nil
 }
 
 
-func (s *Storage) GetStreamEntriesByPartialRange(key string,startTimestamp int64, startIndex int) []map[string]string{
-	s.mu.RLock()
+func (s *Storage) GetStreamEntriesByPartialRange(key string,startTimestamp int64, startIndex int) ([]map[string]string, error) {
+	s.rlock(key)
 	defer s.mu.RUnlock()
-	list, ok := s.streamData[key]
-	if !ok {
-		return []map[string]string{}
+	v, err := s.lookup(key, TypeStream)
+	if v == nil {
+		return []map[string]string{}, err
 	}
+	list := v.Stream
 
 	cb := func(s map[string]string)bool{
 		timestamp, index := parseEntryId(s["id"])				
@@ -269,7 +363,7 @@ func (s *Storage) GetStreamEntriesByPartialRange(key string,startTimestamp int64
 
 	filteredData := utils.FilterPrealloc(list, cb)
 	resp := utils.DeepCopyArrMap(filteredData)
-	return resp
+	return resp, nil
 }
 
 
@@ -281,18 +375,16 @@ func parseEntryId(id string) (int64, int){
 }
 
 func (s *Storage) CheckType(key string) string {
-	s.mu.RLock()
+	s.rlock(key)
 	defer s.mu.RUnlock()
-	
-	if _, ok := s.keyValueData[key]; ok {
-		return "string"
-	}
+	return s.checkType(key)
+}
 
-	if _, ok := s.streamData[key]; ok {
-		return "stream"
+func (s *Storage) checkType(key string) string {
+	if v, ok := s.keyspace[key]; ok && !s.isExpired(key) {
+		return v.Type.String()
 	}
-
-	return "none"
+	return TypeNone.String()
 }
 
 func nomralizeListIndexes(start, stop, listLength int) (int, int, error) {
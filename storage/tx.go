@@ -0,0 +1,117 @@
+package storage
+
+import "time"
+
+// Tx gives access to the data while the lock of the storage is held, so a
+// sequence of reads and writes is applied atomically. A Tx is only valid
+// inside the function passed to Do or View.
+type Tx struct {
+	s        *Storage
+	writable bool
+
+	// lists that received values, their blocked clients are notified once
+	// the lock is released
+	pushed map[string]string
+}
+
+// Do runs fn with exclusive access to the storage
+func (s *Storage) Do(fn func(tx *Tx) error) error {
+	tx := &Tx{s: s, writable: true}
+	err := s.runLocked(tx, fn)
+	for key, value := range tx.pushed {
+		s.NotifyWaiter(key, value)
+	}
+	return err
+}
+
+// View runs fn with read only access to the storage, writing through the Tx
+// panics
+func (s *Storage) View(fn func(tx *Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(&Tx{s: s})
+}
+
+func (s *Storage) runLocked(tx *Tx, fn func(tx *Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(tx)
+}
+
+func (tx *Tx) checkWritable() {
+	if !tx.writable {
+		panic("storage: write in a read only transaction")
+	}
+}
+
+// Get returns the string stored in key, it fails with ErrWrongType if the key
+// holds another type
+func (tx *Tx) Get(key string) (value string, exists bool, err error) {
+	return tx.s.get(key)
+}
+
+func (tx *Tx) Set(key, value string) {
+	tx.checkWritable()
+	tx.s.set(key, value)
+}
+
+func (tx *Tx) DeleteValue(key string) {
+	tx.checkWritable()
+	tx.s.deleteValue(key)
+}
+
+// SetExpire sets the time at which key is deleted, it returns false if the
+// key doesn't exist
+func (tx *Tx) SetExpire(key string, at time.Time) bool {
+	tx.checkWritable()
+	return tx.s.setExpire(key, at)
+}
+
+// ExpireTime returns the unix time in milliseconds at which key expires, -1
+// if the key has no TTL or -2 if it doesn't exist
+func (tx *Tx) ExpireTime(key string) int64 {
+	return tx.s.expireTime(key)
+}
+
+func (tx *Tx) Persist(key string) bool {
+	tx.checkWritable()
+	return tx.s.persist(key)
+}
+
+func (tx *Tx) AppendValuesToList(key string, values ...string) (int, error) {
+	tx.checkWritable()
+	n, err := tx.s.appendValuesToList(key, values...)
+	tx.notify(key, values, n)
+	return n, err
+}
+
+func (tx *Tx) PrependValuesToList(key string, values ...string) (int, error) {
+	tx.checkWritable()
+	n, err := tx.s.prependValuesToList(key, values...)
+	tx.notify(key, values, n)
+	return n, err
+}
+
+func (tx *Tx) GetSliceFromList(key string, start, stop int) ([]string, error) {
+	return tx.s.getSliceFromList(key, start, stop)
+}
+
+func (tx *Tx) GetListLength(key string) (int, error) {
+	return tx.s.getListLength(key)
+}
+
+func (tx *Tx) CheckType(key string) string {
+	return tx.s.checkType(key)
+}
+
+// notify records that an empty list received values, like LPUSH and RPUSH do
+// to wake up the clients blocked on the list
+func (tx *Tx) notify(key string, values []string, n int) {
+	if len(values) == 0 || n != len(values) {
+		return
+	}
+	if tx.pushed == nil {
+		tx.pushed = make(map[string]string)
+	}
+	tx.pushed[key] = values[0]
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDoIsAtomic(t *testing.T) {
+	s := NewStorage()
+	s.Set("counter", "0")
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Do(func(tx *Tx) error {
+				value, _, _ := tx.Get("counter")
+				n, _ := strconv.Atoi(value)
+				tx.Set("counter", strconv.Itoa(n+1))
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	if value, _, _ := s.Get("counter"); value != "50" {
+		t.Errorf("expected 50, got %s", value)
+	}
+}
+
+func TestDoNotifiesBlockedClients(t *testing.T) {
+	s := NewStorage()
+	waiter := make(chan string, 1)
+	s.RegisterWaiter("list", waiter)
+
+	s.Do(func(tx *Tx) error {
+		tx.AppendValuesToList("list", "a", "b")
+		return nil
+	})
+
+	select {
+	case v := <-waiter:
+		if v != "a" {
+			t.Errorf("expected a, got %s", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the blocked client was not notified")
+	}
+}
+
+func TestViewIsReadOnly(t *testing.T) {
+	s := NewStorage()
+	s.AppendValuesToList("list", "a", "b", "c")
+
+	s.View(func(tx *Tx) error {
+		if n, _ := tx.GetListLength("list"); n != 3 {
+			t.Errorf("expected 3 elements, got %d", n)
+		}
+		return nil
+	})
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic writing in a read only transaction")
+		}
+	}()
+	s.View(func(tx *Tx) error {
+		tx.Set("key", "value")
+		return nil
+	})
+}
+
+func TestNotifyWaiterOfSeveralKeys(t *testing.T) {
+	s := NewStorage()
+	waiter := make(chan string, 1)
+	s.RegisterWaiter("a", waiter)
+	s.RegisterWaiter("b", waiter)
+
+	// the second notification doesn't block on the full channel
+	done := make(chan struct{})
+	go func() {
+		s.NotifyWaiter("a", "1")
+		s.NotifyWaiter("b", "2")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("NotifyWaiter blocked on a waiter that was already notified")
+	}
+	if v := <-waiter; v != "1" {
+		t.Errorf("expected the first notification, got %s", v)
+	}
+}
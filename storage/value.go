@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"errors"
+	"maps"
+)
+
+// ErrWrongType is returned when a key is used with an operation of another
+// type, like pushing values to a key that holds a string
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// ValueType is the type of the value stored in a key
+type ValueType int
+
+const (
+	TypeNone ValueType = iota
+	TypeString
+	TypeList
+	TypeHash
+	TypeSet
+	TypeZSet
+	TypeStream
+)
+
+var typeNames = []string{"none", "string", "list", "hash", "set", "zset", "stream"}
+
+// String returns the name of the type reported by TYPE
+func (t ValueType) String() string {
+	return typeNames[t]
+}
+
+// ParseType returns the type with the name reported by TYPE
+func ParseType(name string) (ValueType, bool) {
+	for i, typeName := range typeNames {
+		if typeName == name {
+			return ValueType(i), true
+		}
+	}
+	return TypeNone, false
+}
+
+// Value is the object stored in a key, only the field of its type is used
+type Value struct {
+	Type   ValueType
+	Str    string
+	List   []string
+	Hash   map[string]string
+	Set    map[string]struct{}
+	ZSet   map[string]float64
+	Stream []map[string]string
+}
+
+// clone returns a deep copy of the value, like COPY does
+func (v *Value) clone() *Value {
+	c := &Value{Type: v.Type, Str: v.Str}
+	switch v.Type {
+	case TypeList:
+		c.List = append([]string{}, v.List...)
+	case TypeHash:
+		c.Hash = maps.Clone(v.Hash)
+	case TypeSet:
+		c.Set = maps.Clone(v.Set)
+	case TypeZSet:
+		c.ZSet = maps.Clone(v.ZSet)
+	case TypeStream:
+		c.Stream = make([]map[string]string, len(v.Stream))
+		for i, entry := range v.Stream {
+			c.Stream[i] = maps.Clone(entry)
+		}
+	}
+	return c
+}
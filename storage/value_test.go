@@ -0,0 +1,50 @@
+package storage
+
+import "testing"
+
+func TestKeyspaceTypes(t *testing.T) {
+	s := NewStorage()
+	s.Set("str", "value")
+	s.AppendValuesToList("list", "a", "b")
+	s.AddEntryStream("stream", map[string]string{"id": "1-1"})
+
+	for key, expected := range map[string]string{"str": "string", "list": "list", "stream": "stream", "missing": "none"} {
+		if typ := s.CheckType(key); typ != expected {
+			t.Errorf("%s: expected type %s, got %s", key, expected, typ)
+		}
+	}
+
+	// every operation fails against a key of another type
+	ops := map[string]func(key string) error{
+		"get":    func(key string) error { _, _, err := s.Get(key); return err },
+		"append": func(key string) error { _, err := s.AppendValuesToList(key, "x"); return err },
+		"range":  func(key string) error { _, err := s.GetSliceFromList(key, 0, -1); return err },
+		"len":    func(key string) error { _, err := s.GetListLenght(key); return err },
+		"pop":    func(key string) error { _, err := s.RemoveFirstElementFromTheList(key); return err },
+		"xadd":   func(key string) error { return s.AddEntryStream(key, map[string]string{"id": "2-1"}) },
+		"xrange": func(key string) error { _, err := s.GetStreamEntriesByRange(key, 0, 0, 0, 0); return err },
+	}
+	owner := map[string]string{"get": "str", "append": "list", "range": "list", "len": "list", "pop": "list", "xadd": "stream", "xrange": "stream"}
+	for name, op := range ops {
+		for _, key := range []string{"str", "list", "stream"} {
+			err := op(key)
+			if key == owner[name] && err != nil {
+				t.Errorf("%s on %s: unexpected error %v", name, key, err)
+			}
+			if key != owner[name] && err != ErrWrongType {
+				t.Errorf("%s on %s: expected ErrWrongType, got %v", name, key, err)
+			}
+		}
+	}
+
+	// SET replaces a value of any type and popping the last element deletes the list
+	s.Set("list", "now a string")
+	if typ := s.CheckType("list"); typ != "string" {
+		t.Errorf("expected SET to replace the list, got type %s", typ)
+	}
+	s.AppendValuesToList("single", "a")
+	s.RemoveFirstElementFromTheList("single")
+	if typ := s.CheckType("single"); typ != "none" {
+		t.Errorf("expected the empty list to be deleted, got type %s", typ)
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+// Watch is the set of keys watched by a client with WATCH, it becomes dirty
+// when one of the keys is modified. Every write path of the storage marks the
+// watches of the key it modifies while the lock is held.
+type Watch struct {
+	s     *Storage
+	keys  []string
+	dirty bool
+
+	// keys that existed when they were added, a key that expires counts as
+	// modified even if it wasn't deleted yet
+	live []string
+}
+
+func (s *Storage) NewWatch() *Watch {
+	return &Watch{s: s}
+}
+
+// Add starts watching keys, a key can be added more than once
+func (w *Watch) Add(keys ...string) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	for _, key := range keys {
+		// a key that already expired is deleted now, so deleting it later
+		// doesn't make the watch dirty
+		w.s.expireIfNeeded(key)
+		watchers, ok := w.s.watchers[key]
+		if !ok {
+			watchers = make(map[*Watch]struct{})
+			w.s.watchers[key] = watchers
+		}
+		if _, ok := watchers[w]; !ok {
+			watchers[w] = struct{}{}
+			w.keys = append(w.keys, key)
+			if _, exists := w.s.keyspace[key]; exists {
+				w.live = append(w.live, key)
+			}
+		}
+	}
+}
+
+// Dirty reports whether a watched key was modified or expired since it was
+// added
+func (w *Watch) Dirty() bool {
+	w.s.mu.RLock()
+	defer w.s.mu.RUnlock()
+	if w.dirty {
+		return true
+	}
+	for _, key := range w.live {
+		if w.s.isExpired(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Clear stops watching all the keys and resets the dirty state
+func (w *Watch) Clear() {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	for _, key := range w.keys {
+		delete(w.s.watchers[key], w)
+		if len(w.s.watchers[key]) == 0 {
+			delete(w.s.watchers, key)
+		}
+	}
+	w.keys = nil
+	w.live = nil
+	w.dirty = false
+}
+
+// touch marks the watches of a modified key as dirty, the caller must hold
+// the write lock
+func (s *Storage) touch(key string) {
+	for w := range s.watchers[key] {
+		w.dirty = true
+	}
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchWritePaths(t *testing.T) {
+	writes := []struct {
+		name  string
+		setup func(s *Storage)
+		write func(s *Storage)
+	}{
+		{"set", func(s *Storage) {}, func(s *Storage) { s.Set("key", "v") }},
+		{"delete", func(s *Storage) { s.Set("key", "v") }, func(s *Storage) { s.DeleteValue("key") }},
+		{"rpush", func(s *Storage) {}, func(s *Storage) { s.AppendValuesToList("key", "a") }},
+		{"lpush", func(s *Storage) {}, func(s *Storage) { s.PrependValuesToList("key", "a") }},
+		{"lpop", func(s *Storage) { s.AppendValuesToList("key", "a", "b") }, func(s *Storage) { s.RemoveFirstElementFromTheList("key") }},
+		{"remove by index", func(s *Storage) { s.AppendValuesToList("key", "a") }, func(s *Storage) { s.RemoveElementFromListByIndex("key", 0) }},
+		{"remove range", func(s *Storage) { s.AppendValuesToList("key", "a", "b") }, func(s *Storage) { s.RemoveFirstElementsFromTheList("key", 1) }},
+		{"xadd", func(s *Storage) {}, func(s *Storage) { s.AddEntryStream("key", map[string]string{"id": "1-1"}) }},
+		{"tx", func(s *Storage) {}, func(s *Storage) {
+			s.Do(func(tx *Tx) error {
+				tx.Set("key", "v")
+				return nil
+			})
+		}},
+	}
+
+	for _, c := range writes {
+		s := NewStorage()
+		c.setup(s)
+		w := s.NewWatch()
+		w.Add("key", "other")
+		if w.Dirty() {
+			t.Errorf("%s: the watch must be clean before the write", c.name)
+		}
+		c.write(s)
+		if !w.Dirty() {
+			t.Errorf("%s: expected the watch to be dirty after the write", c.name)
+		}
+	}
+}
+
+func TestWatchIgnoresOtherKeysAndNoops(t *testing.T) {
+	s := NewStorage()
+	w := s.NewWatch()
+	w.Add("key")
+
+	s.Set("other", "v")
+	s.DeleteValue("key")
+	s.RemoveFirstElementFromTheList("key")
+	if w.Dirty() {
+		t.Errorf("the watch must only be dirty when a watched key is modified")
+	}
+}
+
+func TestWatchClear(t *testing.T) {
+	s := NewStorage()
+	w := s.NewWatch()
+	w.Add("key")
+	s.Set("key", "v")
+	w.Clear()
+
+	if w.Dirty() {
+		t.Errorf("clear must reset the dirty state")
+	}
+	s.Set("key", "v2")
+	if w.Dirty() {
+		t.Errorf("the keys must not be watched after clear")
+	}
+	if len(s.watchers) != 0 {
+		t.Errorf("expected no watchers left, got %d", len(s.watchers))
+	}
+}
+
+func TestWatchExpiredKeys(t *testing.T) {
+	s := NewStorage()
+	now := time.UnixMilli(1000)
+	s.now = func() time.Time { return now }
+	s.Set("key", "v")
+	s.SetExpire("key", now.Add(time.Second))
+	s.Set("expired", "v")
+	s.SetExpire("expired", now)
+
+	now = now.Add(time.Millisecond)
+	w := s.NewWatch()
+	w.Add("key", "expired")
+	if w.Dirty() {
+		t.Errorf("a key that expired before WATCH must not make the watch dirty")
+	}
+
+	// the key expires but nobody deleted it yet
+	now = now.Add(time.Second)
+	if !w.Dirty() {
+		t.Errorf("expected the expired key to make the watch dirty")
+	}
+}
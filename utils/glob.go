@@ -0,0 +1,91 @@
+package utils
+
+// MatchGlob reports whether s matches the glob-style pattern the way redis
+// matches the patterns of KEYS and SCAN:
+//   - '*' matches any sequence of characters and '?' a single character
+//   - [abc] matches one of the characters, [^abc] any character but them and
+//     [a-z] a range of characters
+//   - '\' escapes the next character, also inside brackets
+func MatchGlob(pattern, s string) bool {
+	p, i := 0, 0
+	// position of the last star and of the text it matches, a mismatch
+	// retries after the star consuming one more character
+	star, starMatch := -1, 0
+	for i < len(s) {
+		if p < len(pattern) && pattern[p] == '*' {
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			if p == len(pattern) {
+				return true
+			}
+			star, starMatch = p, i
+			continue
+		}
+		if p < len(pattern) {
+			if n, ok := matchChar(pattern[p:], s[i]); ok {
+				p += n
+				i++
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starMatch++
+		p, i = star, starMatch
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchChar matches c against the first element of pattern, which is not a
+// star. It returns the length of the element.
+func matchChar(pattern string, c byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '[':
+		return matchClass(pattern, c)
+	case '\\':
+		// a trailing backslash matches itself
+		if len(pattern) >= 2 {
+			return 2, pattern[1] == c
+		}
+	}
+	return 1, pattern[0] == c
+}
+
+// matchClass matches c against the class at the start of pattern, a class
+// without the closing bracket ends with the pattern
+func matchClass(pattern string, c byte) (int, bool) {
+	j := 1
+	not := j < len(pattern) && pattern[j] == '^'
+	if not {
+		j++
+	}
+	match := false
+	for j < len(pattern) && pattern[j] != ']' {
+		switch {
+		case pattern[j] == '\\' && j+1 < len(pattern):
+			match = match || pattern[j+1] == c
+			j += 2
+		case j+2 < len(pattern) && pattern[j+1] == '-':
+			start, end := pattern[j], pattern[j+2]
+			if start > end {
+				start, end = end, start
+			}
+			match = match || (start <= c && c <= end)
+			j += 3
+		default:
+			match = match || pattern[j] == c
+			j++
+		}
+	}
+	if j < len(pattern) {
+		j++
+	}
+	return j, match != not
+}
@@ -0,0 +1,37 @@
+package utils
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"*o*o*", "foo bar foo", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hallo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h[\]]llo`, "h]llo", true},
+		{`foo\`, `foo\`, true},
+		{"user:[0-9]*", "user:42:name", true},
+		{"[abc", "b", true},
+		{"a**b", "ab", true},
+	}
+	for _, c := range cases {
+		if got := MatchGlob(c.pattern, c.s); got != c.match {
+			t.Errorf("MatchGlob(%q, %q) = %v, expected %v", c.pattern, c.s, got, c.match)
+		}
+	}
+}